@@ -0,0 +1,31 @@
+// Package comkirtest builds COMKIR resource sets for tests.
+package comkirtest
+
+import (
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceSet returns the resource set of YAML manifests keyed by component/Kind/name. The source of every resource
+// is its key with a .yaml extension.
+func ResourceSet(t testing.TB, manifests map[string]string) *comkir.ResourceSet {
+	t.Helper()
+
+	rs := &comkir.ResourceSet{Components: make(map[string][]*comkir.Resource)}
+	for path, manifest := range manifests {
+		parts := strings.Split(path, "/")
+		if len(parts) != 3 {
+			t.Fatalf("manifest key %q is not of the form component/Kind/name", path)
+		}
+		res := &comkir.Resource{Source: path + ".yaml", Component: parts[0], Kind: parts[1], Name: parts[2]}
+		err := yaml.Unmarshal([]byte(manifest), &res.Contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Components[res.Component] = append(rs.Components[res.Component], res)
+	}
+	return rs
+}
@@ -0,0 +1,53 @@
+package comkir
+
+import "strings"
+
+// podSpecPaths are the field paths of the pod spec in the kinds of workload resources.
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// MapField returns the nested map at path in m.
+func MapField(m map[string]interface{}, path ...string) (map[string]interface{}, bool) {
+	for _, field := range path {
+		var ok bool
+		m, ok = m[field].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// PodSpec returns the pod spec of a workload resource and its field path, like spec.template.spec.
+func (r *Resource) PodSpec() (map[string]interface{}, string, bool) {
+	path, ok := podSpecPaths[r.Kind]
+	if !ok {
+		return nil, "", false
+	}
+	spec, ok := MapField(r.Contents, path...)
+	return spec, strings.Join(path, "."), ok
+}
+
+// PodLabels returns the labels of the pods of a workload resource.
+func (r *Resource) PodLabels() (map[string]interface{}, bool) {
+	path, ok := podSpecPaths[r.Kind]
+	if !ok {
+		return nil, false
+	}
+	// the labels are in the metadata next to the pod spec
+	return MapField(r.Contents, append(append([]string{}, path[:len(path)-1]...), "metadata", "labels")...)
+}
+
+// Namespace returns the namespace of the resource, empty if it has none.
+func (r *Resource) Namespace() string {
+	metadata, _ := MapField(r.Contents, "metadata")
+	namespace, _ := metadata["namespace"].(string)
+	return namespace
+}
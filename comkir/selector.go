@@ -0,0 +1,126 @@
+package comkir
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelSelector selects pods by their labels, like the selector of a Service or the label selector of a workload or
+// PodDisruptionBudget.
+type LabelSelector struct {
+	MatchLabels      map[string]interface{}
+	MatchExpressions []SelectorRequirement
+}
+
+// SelectorRequirement is an expression of a label selector, like tier In (frontend, backend).
+type SelectorRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// NewLabelSelector returns the label selector with the matchLabels and matchExpressions of m.
+func NewLabelSelector(m map[string]interface{}) *LabelSelector {
+	s := &LabelSelector{}
+	s.MatchLabels, _ = MapField(m, "matchLabels")
+	expressions, _ := m["matchExpressions"].([]interface{})
+	for _, e := range expressions {
+		expression, _ := e.(map[string]interface{})
+		r := SelectorRequirement{}
+		r.Key, _ = expression["key"].(string)
+		r.Operator, _ = expression["operator"].(string)
+		values, _ := expression["values"].([]interface{})
+		for _, v := range values {
+			r.Values = append(r.Values, fmt.Sprint(v))
+		}
+		s.MatchExpressions = append(s.MatchExpressions, r)
+	}
+	return s
+}
+
+// PodSelector returns the selector of the pods of a Service, PodDisruptionBudget or workload.
+func (r *Resource) PodSelector() (*LabelSelector, bool) {
+	switch r.Kind {
+	case "Service":
+		selector, ok := MapField(r.Contents, "spec", "selector")
+		if !ok {
+			return nil, false
+		}
+		return &LabelSelector{MatchLabels: selector}, true
+	case "PodDisruptionBudget", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet":
+		selector, ok := MapField(r.Contents, "spec", "selector")
+		if !ok {
+			return nil, false
+		}
+		return NewLabelSelector(selector), true
+	}
+	return nil, false
+}
+
+// Empty reports whether the selector has neither labels nor expressions.
+func (s *LabelSelector) Empty() bool {
+	return s == nil || (len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0)
+}
+
+// Matches reports whether labels satisfy every label and expression of the selector. An empty selector matches
+// nothing.
+func (s *LabelSelector) Matches(labels map[string]interface{}) bool {
+	if s.Empty() {
+		return false
+	}
+	for k, v := range s.MatchLabels {
+		if l, ok := labels[k]; !ok || fmt.Sprint(l) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	for _, r := range s.MatchExpressions {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r SelectorRequirement) matches(labels map[string]interface{}) bool {
+	l, ok := labels[r.Key]
+	in := false
+	for _, v := range r.Values {
+		if ok && fmt.Sprint(l) == v {
+			in = true
+		}
+	}
+	switch r.Operator {
+	case "In":
+		return in
+	case "NotIn":
+		return !in
+	case "Exists":
+		return ok
+	case "DoesNotExist":
+		return !ok
+	}
+	return false
+}
+
+// String formats the selector like {app=frontend, tier in (backend, frontend), !canary}.
+func (s *LabelSelector) String() string {
+	var terms []string
+	if s != nil {
+		for k, v := range s.MatchLabels {
+			terms = append(terms, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(terms)
+		for _, r := range s.MatchExpressions {
+			switch r.Operator {
+			case "Exists":
+				terms = append(terms, r.Key)
+			case "DoesNotExist":
+				terms = append(terms, "!"+r.Key)
+			default:
+				terms = append(terms, fmt.Sprintf("%s %s (%s)", r.Key, strings.ToLower(r.Operator), strings.Join(r.Values, ", ")))
+			}
+		}
+	}
+	return "{" + strings.Join(terms, ", ") + "}"
+}
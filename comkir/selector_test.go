@@ -0,0 +1,39 @@
+package comkir
+
+import "testing"
+
+func TestLabelSelector(t *testing.T) {
+	s := NewLabelSelector(map[string]interface{}{
+		"matchLabels": map[string]interface{}{"app": "frontend"},
+		"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "tier", "operator": "In", "values": []interface{}{"web", "api"}},
+			map[string]interface{}{"key": "track", "operator": "NotIn", "values": []interface{}{"canary"}},
+			map[string]interface{}{"key": "version", "operator": "Exists"},
+			map[string]interface{}{"key": "deprecated", "operator": "DoesNotExist"},
+		},
+	})
+	if got := s.String(); got != "{app=frontend, tier in (web, api), track notin (canary), version, !deprecated}" {
+		t.Errorf("unexpected selector %s", got)
+	}
+
+	for _, tc := range []struct {
+		labels map[string]interface{}
+		want   bool
+	}{
+		{map[string]interface{}{"app": "frontend", "tier": "web", "version": 3}, true},
+		{map[string]interface{}{"app": "frontend", "tier": "api", "track": "stable", "version": "3"}, true},
+		{map[string]interface{}{"app": "frontend", "tier": "db", "version": 3}, false},
+		{map[string]interface{}{"app": "frontend", "tier": "web", "track": "canary", "version": 3}, false},
+		{map[string]interface{}{"app": "frontend", "tier": "web"}, false},
+		{map[string]interface{}{"app": "frontend", "tier": "web", "version": 3, "deprecated": "true"}, false},
+		{map[string]interface{}{"tier": "web", "version": 3}, false},
+	} {
+		if got := s.Matches(tc.labels); got != tc.want {
+			t.Errorf("%v: expected %v, got %v", tc.labels, tc.want, got)
+		}
+	}
+
+	if (&LabelSelector{}).Matches(map[string]interface{}{"app": "frontend"}) {
+		t.Errorf("expected an empty selector to match nothing")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"ds-to-dhall/console"
+	"ds-to-dhall/dhall2ds"
+	"ds-to-dhall/dockerimg"
+	"ds-to-dhall/ds2dhall"
+	"ds-to-dhall/graph"
+	"ds-to-dhall/lint"
+	"ds-to-dhall/refs"
+	"ds-to-dhall/report"
+	"ds-to-dhall/validate"
+	flag "github.com/spf13/pflag"
+)
+
+// command is a subcommand of ds-to-dhall. Commands without flags are built into main.
+type command struct {
+	name             string
+	shortDescription string
+	longDescription  string
+	examples         []string
+
+	main  func([]string, context.Context)
+	flags func() *flag.FlagSet
+	// subcommands are nested commands selected by the first argument, like dockerimg set
+	subcommands []*command
+	// args are the values the first argument completes to
+	args []string
+}
+
+// commandRegistry keeps the commands in the order they are listed in the help.
+type commandRegistry struct {
+	commands    []*command
+	globalFlags *flag.FlagSet
+}
+
+func (r *commandRegistry) register(c *command) {
+	if _, ok := r.lookup(c.name); ok {
+		panic(fmt.Sprintf("command %s is registered twice", c.name))
+	}
+	r.commands = append(r.commands, c)
+}
+
+func (r *commandRegistry) lookup(name string) (*command, bool) {
+	for _, c := range r.commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (c *command) subcommand(name string) (*command, bool) {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub, true
+		}
+	}
+	return nil, false
+}
+
+func (r *commandRegistry) names() []string {
+	names := make([]string, 0, len(r.commands))
+	for _, c := range r.commands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func (r *commandRegistry) writeUsage(w io.Writer) {
+	fmt.Fprintln(w, "ds-to-dhall [global options] <command>")
+	fmt.Fprintln(w, "available commands:")
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, c := range r.commands {
+		fmt.Fprintf(tw, "\t%s\t%s\n", c.name, c.shortDescription)
+	}
+	tw.Flush()
+	fmt.Fprint(w, usageGlobalOptions(r.globalFlags))
+	fmt.Fprintln(w, "use \"ds-to-dhall help <command>\" for more information about a command")
+}
+
+// writeHelp writes the long description and the examples of the command. The options of commands with flags are
+// described by the usage of their flag set.
+func (c *command) writeHelp(w io.Writer) {
+	if c.flags != nil {
+		flagSet := c.flags()
+		console.SetUsageOutput(flagSet, w)
+		flagSet.Usage()
+	} else {
+		fmt.Fprintf(w, "%s %s\n", c.name, c.shortDescription)
+	}
+	if c.longDescription != "" {
+		fmt.Fprintf(w, "\n%s\n", c.longDescription)
+	}
+	if len(c.examples) > 0 {
+		fmt.Fprintln(w, "\nEXAMPLES:")
+		for _, example := range c.examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
+
+func dockerimgSubcommands() []*command {
+	var subcommands []*command
+	for _, sub := range dockerimg.Subcommands() {
+		subcommands = append(subcommands, &command{
+			name:             sub.Name,
+			shortDescription: sub.ShortDescription,
+			main:             sub.Main,
+			flags:            sub.Flags,
+		})
+	}
+	return subcommands
+}
+
+func reportSubcommands() []*command {
+	var subcommands []*command
+	for _, sub := range report.Subcommands() {
+		subcommands = append(subcommands, &command{
+			name:             sub.Name,
+			shortDescription: sub.ShortDescription,
+			main:             sub.Main,
+			flags:            sub.Flags,
+		})
+	}
+	return subcommands
+}
+
+// newCommandRegistry registers the commands of ds-to-dhall.
+func newCommandRegistry(globalFlags *flag.FlagSet) *commandRegistry {
+	r := &commandRegistry{globalFlags: globalFlags}
+
+	r.register(&command{
+		name:             "ds2dhall",
+		shortDescription: ds2dhall.ShortDescription,
+		longDescription: "Converts the Kubernetes manifests of a deploy-sourcegraph tree into a Dhall record organized by\n" +
+			"component, kind and name, typed with the dhall-kubernetes schema.",
+		examples: []string{
+			"ds-to-dhall ds2dhall --output record.dhall ~/work/deploy-sourcegraph/base",
+			"ds-to-dhall ds2dhall --cache ~/.cache/ds-to-dhall --split-dir record ~/work/deploy-sourcegraph/base",
+		},
+		main:  ds2dhall.Main,
+		flags: ds2dhall.Flags,
+	})
+	r.register(&command{
+		name:             "dockerimg",
+		shortDescription: dockerimg.ShortDescription,
+		longDescription: "Scans YAML and Dhall files for container images and prints them as a Dhall record keyed by image\n" +
+			"name, or in one of the other output formats.",
+		examples: []string{
+			"ds-to-dhall dockerimg ~/work/deploy-sourcegraph/base > images.dhall",
+			"ds-to-dhall dockerimg --format json --resolve-digests ~/work/deploy-sourcegraph/base",
+			"ds-to-dhall dockerimg set --image sourcegraph/frontend=3.21.0 ~/work/deploy-sourcegraph/base",
+		},
+		main:        dockerimg.Main,
+		flags:       dockerimg.Flags,
+		subcommands: dockerimgSubcommands(),
+	})
+	r.register(&command{
+		name:             "dhall2ds",
+		shortDescription: dhall2ds.ShortDescription,
+		longDescription: "Evaluates a COMKIR Dhall record with dhall-to-yaml and writes each resource to\n" +
+			"<output>/<component>/<Kind>.<name>.yaml.",
+		examples: []string{
+			"ds-to-dhall dhall2ds --output ~/work/deploy-sourcegraph/base record.dhall",
+		},
+		main:  dhall2ds.Main,
+		flags: dhall2ds.Flags,
+	})
+	r.register(&command{
+		name:             "validate",
+		shortDescription: validate.ShortDescription,
+		longDescription: "Checks every resource against the schema of its apiVersion and kind in a Kubernetes OpenAPI spec\n" +
+			"and reports all type mismatches, unknown fields and missing required fields with their file and path.",
+		examples: []string{
+			"ds-to-dhall validate --spec swagger.json ~/work/deploy-sourcegraph/base",
+		},
+		main:  validate.Main,
+		flags: validate.Flags,
+	})
+	r.register(&command{
+		name:             "lint",
+		shortDescription: lint.ShortDescription,
+		longDescription: "Runs best practice rules over every resource, like resource requests and limits, probes,\n" +
+			"running as non-root, no hostPath volumes and PodDisruptionBudgets for replicated deployments. A config\n" +
+			"file sets the severity of the rules for all or single components. Exits with status 1 on errors.",
+		examples: []string{
+			"ds-to-dhall lint ~/work/deploy-sourcegraph/base",
+			"ds-to-dhall lint --config lint.yaml --format sarif record.dhall > lint.sarif",
+		},
+		main:  lint.Main,
+		flags: lint.Flags,
+	})
+	r.register(&command{
+		name:             "refs",
+		shortDescription: refs.ShortDescription,
+		longDescription: "Resolves the ConfigMap, Secret, PersistentVolumeClaim, ServiceAccount, role and Service references of\n" +
+			"every resource across all components, and checks that the selectors of services and PodDisruptionBudgets\n" +
+			"match pods and that workload selectors match their pod template. Exits with status 1 on problems.",
+		examples: []string{
+			"ds-to-dhall refs ~/work/deploy-sourcegraph/base",
+			"ds-to-dhall refs --external Secret/sourcegraph-tls record.dhall",
+		},
+		main:  refs.Main,
+		flags: refs.Flags,
+	})
+	r.register(&command{
+		name:             "graph",
+		shortDescription: graph.ShortDescription,
+		longDescription: "Builds a graph of the resources grouped by component, with edges from services and PodDisruptionBudgets\n" +
+			"to the workloads they select, from workloads to their ConfigMaps, Secrets, PersistentVolumeClaims and\n" +
+			"ServiceAccounts, from role bindings to their roles and from ingresses to their services.",
+		examples: []string{
+			"ds-to-dhall graph ~/work/deploy-sourcegraph/base | dot -Tsvg > resources.svg",
+			"ds-to-dhall graph --format mermaid record.dhall",
+		},
+		main:  graph.Main,
+		flags: graph.Flags,
+	})
+	r.register(&command{
+		name:             "report",
+		shortDescription: report.ShortDescription,
+		longDescription: "Reports on the resources of a manifest tree or a COMKIR Dhall record. report resources tallies the\n" +
+			"CPU and memory requests and limits of the workloads times their replicas, and the storage of their claims,\n" +
+			"per component.",
+		examples: []string{
+			"ds-to-dhall report resources ~/work/deploy-sourcegraph/base",
+			"ds-to-dhall report resources --nodes 3 --format json record.dhall",
+		},
+		main:        report.Main,
+		flags:       report.Flags,
+		subcommands: reportSubcommands(),
+	})
+
+	r.register(&command{
+		name:             "help",
+		shortDescription: "shows help for commands",
+		examples:         []string{"ds-to-dhall help dockerimg", "ds-to-dhall help dockerimg set"},
+		args:             append(r.names(), "help", "version", "completion"),
+		main: func(args []string, ctx context.Context) {
+			if len(args) == 0 {
+				r.writeUsage(os.Stdout)
+				return
+			}
+			c, ok := r.lookup(args[0])
+			if !ok {
+				r.unknownCommand(args[0])
+			}
+			// help dockerimg set shows the help of the nested command
+			for _, name := range args[1:] {
+				sub, ok := c.subcommand(name)
+				if !ok {
+					fmt.Fprintf(os.Stderr, "unknown subcommand %s of %s\n", name, c.name)
+					os.Exit(1)
+				}
+				c = sub
+			}
+			c.writeHelp(os.Stdout)
+		},
+	})
+	r.register(&command{
+		name:             "version",
+		shortDescription: "shows version string",
+		main: func(args []string, ctx context.Context) {
+			fmt.Fprintln(os.Stderr, versionString(version, commit, date))
+		},
+	})
+	r.register(&command{
+		name:             "completion",
+		shortDescription: "prints a bash, zsh or fish completion script",
+		longDescription: "The script completes the commands, their subcommands and their options. Load it in the\n" +
+			"configuration of your shell.",
+		examples: []string{
+			"source <(ds-to-dhall completion bash)",
+			"ds-to-dhall completion zsh > \"${fpath[1]}/_ds-to-dhall\"",
+			"ds-to-dhall completion fish > ~/.config/fish/completions/ds-to-dhall.fish",
+		},
+		args: []string{"bash", "zsh", "fish"},
+		main: func(args []string, ctx context.Context) {
+			if len(args) != 1 {
+				fmt.Fprintln(os.Stderr, "expected a shell: bash, zsh or fish")
+				os.Exit(1)
+			}
+			err := writeCompletion(os.Stdout, args[0], r)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	})
+
+	return r
+}
+
+func (r *commandRegistry) unknownCommand(name string) {
+	fmt.Fprintf(os.Stderr, "unknown subcommand %s\n", name)
+	fmt.Fprintf(os.Stderr, "expected a subcommand: %s\n", strings.Join(r.names(), ", "))
+	os.Exit(1)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestWriteHelp(t *testing.T) {
+	r := newCommandRegistry(flag.NewFlagSet("ds-to-dhall", flag.ContinueOnError))
+
+	dockerimg, ok := r.lookup("dockerimg")
+	if !ok {
+		t.Fatal("dockerimg is not registered")
+	}
+	set, ok := dockerimg.subcommand("set")
+	if !ok {
+		t.Fatal("dockerimg set is not registered")
+	}
+
+	// the usage of the flag set goes to the writer as well
+	var b bytes.Buffer
+	set.writeHelp(&b)
+	for _, want := range []string{"Usage of ds-to-dhall dockerimg set", "--dry-run", "ARGS:"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected %q in the help\n%s", want, b.String())
+		}
+	}
+}
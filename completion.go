@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// completionNode is a position on the command line that completes to its own words: the global options and commands
+// before a command, a command and its subcommands, or a nested subcommand.
+type completionNode struct {
+	// path are the commands leading to the node, empty for the top level
+	path        []string
+	flags       []*flag.Flag
+	subcommands []*command
+	args        []string
+}
+
+// words are the options, subcommands and args the node completes to.
+func (n *completionNode) words() []string {
+	var words []string
+	for _, f := range n.flags {
+		words = append(words, "--"+f.Name)
+		if f.Shorthand != "" {
+			words = append(words, "-"+f.Shorthand)
+		}
+	}
+	for _, sub := range n.subcommands {
+		words = append(words, sub.name)
+	}
+	return append(words, n.args...)
+}
+
+func visibleFlags(flagSet *flag.FlagSet) []*flag.Flag {
+	var flags []*flag.Flag
+	if flagSet == nil {
+		return flags
+	}
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if !f.Hidden {
+			flags = append(flags, f)
+		}
+	})
+	return flags
+}
+
+func commandNode(path []string, c *command) completionNode {
+	var flagSet *flag.FlagSet
+	if c.flags != nil {
+		flagSet = c.flags()
+	}
+	return completionNode{path: path, flags: visibleFlags(flagSet), subcommands: c.subcommands, args: c.args}
+}
+
+// completionNodes returns the top level followed by every command and nested subcommand.
+func (r *commandRegistry) completionNodes() []completionNode {
+	nodes := []completionNode{{flags: visibleFlags(r.globalFlags), subcommands: r.commands}}
+	for _, c := range r.commands {
+		nodes = append(nodes, commandNode([]string{c.name}, c))
+		for _, sub := range c.subcommands {
+			nodes = append(nodes, commandNode([]string{c.name, sub.name}, sub))
+		}
+	}
+	return nodes
+}
+
+// writeCommandDetection writes the shell code shared by bash and zsh that sets cmd and sub to the command and
+// subcommand found in the words before the cursor, which are read from the variable word.
+func writeCommandDetection(b *bytes.Buffer, r *commandRegistry, indent string) {
+	fmt.Fprintf(b, "%sif [[ -z \"$cmd\" ]]; then\n", indent)
+	fmt.Fprintf(b, "%s    case \"$word\" in\n", indent)
+	fmt.Fprintf(b, "%s        %s) cmd=\"$word\" ;;\n", indent, strings.Join(r.names(), "|"))
+	fmt.Fprintf(b, "%s    esac\n", indent)
+	fmt.Fprintf(b, "%selif [[ -z \"$sub\" ]]; then\n", indent)
+	fmt.Fprintf(b, "%s    case \"$cmd $word\" in\n", indent)
+	for _, c := range r.commands {
+		for _, sub := range c.subcommands {
+			fmt.Fprintf(b, "%s        \"%s %s\") sub=\"$word\" ;;\n", indent, c.name, sub.name)
+		}
+	}
+	fmt.Fprintf(b, "%s    esac\n", indent)
+	fmt.Fprintf(b, "%sfi\n", indent)
+}
+
+// casePattern is the value of "$cmd $sub" at the node.
+func (n *completionNode) casePattern() string {
+	path := append(append([]string{}, n.path...), "", "")
+	return fmt.Sprintf("%q", path[0]+" "+path[1])
+}
+
+func writeBashCompletion(b *bytes.Buffer, r *commandRegistry) {
+	b.WriteString("# bash completion for ds-to-dhall, generated by ds-to-dhall completion bash\n")
+	b.WriteString("_ds_to_dhall() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    local cmd=\"\" sub=\"\" word i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        word=\"${COMP_WORDS[i]}\"\n")
+	writeCommandDetection(b, r, "        ")
+	b.WriteString("    done\n\n")
+	b.WriteString("    local words=\"\"\n")
+	b.WriteString("    case \"$cmd $sub\" in\n")
+	for _, n := range r.completionNodes() {
+		fmt.Fprintf(b, "        %s) words=\"%s\" ;;\n", n.casePattern(), strings.Join(n.words(), " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("}\n\n")
+	b.WriteString("complete -o default -F _ds_to_dhall ds-to-dhall\n")
+}
+
+func writeZshCompletion(b *bytes.Buffer, r *commandRegistry) {
+	b.WriteString("#compdef ds-to-dhall\n")
+	b.WriteString("# zsh completion for ds-to-dhall, generated by ds-to-dhall completion zsh\n")
+	b.WriteString("_ds_to_dhall() {\n")
+	b.WriteString("    local cmd=\"\" sub=\"\" word i\n")
+	b.WriteString("    for ((i = 2; i < CURRENT; i++)); do\n")
+	b.WriteString("        word=\"${words[i]}\"\n")
+	writeCommandDetection(b, r, "        ")
+	b.WriteString("    done\n\n")
+	b.WriteString("    local -a candidates\n")
+	b.WriteString("    case \"$cmd $sub\" in\n")
+	for _, n := range r.completionNodes() {
+		fmt.Fprintf(b, "        %s) candidates=(%s) ;;\n", n.casePattern(), strings.Join(n.words(), " "))
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    compadd -a candidates\n")
+	b.WriteString("    if [[ \"$PREFIX\" != -* ]]; then\n")
+	b.WriteString("        _files\n")
+	b.WriteString("    fi\n")
+	b.WriteString("}\n\n")
+	b.WriteString("compdef _ds_to_dhall ds-to-dhall\n")
+}
+
+// fishQuote quotes s for fish, which only interprets \\ and \' inside single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// fishCondition is the condition under which the words of the node are completed.
+func (n *completionNode) fishCondition(r *commandRegistry) string {
+	switch len(n.path) {
+	case 0:
+		return "__fish_use_subcommand"
+	case 1:
+		c, _ := r.lookup(n.path[0])
+		condition := "__fish_seen_subcommand_from " + n.path[0]
+		if len(c.subcommands) > 0 {
+			var names []string
+			for _, sub := range c.subcommands {
+				names = append(names, sub.name)
+			}
+			condition += "; and not __fish_seen_subcommand_from " + strings.Join(names, " ")
+		}
+		return condition
+	}
+	return fmt.Sprintf("__fish_seen_subcommand_from %s; and __fish_seen_subcommand_from %s", n.path[0], n.path[1])
+}
+
+func writeFishCompletion(b *bytes.Buffer, r *commandRegistry) {
+	b.WriteString("# fish completion for ds-to-dhall, generated by ds-to-dhall completion fish\n")
+	for _, n := range r.completionNodes() {
+		condition := fishQuote(n.fishCondition(r))
+		for _, sub := range n.subcommands {
+			fmt.Fprintf(b, "complete -c ds-to-dhall -f -n %s -a %s -d %s\n", condition, sub.name,
+				fishQuote(sub.shortDescription))
+		}
+		if len(n.args) > 0 {
+			fmt.Fprintf(b, "complete -c ds-to-dhall -f -n %s -a %s\n", condition, fishQuote(strings.Join(n.args, " ")))
+		}
+		for _, f := range n.flags {
+			fmt.Fprintf(b, "complete -c ds-to-dhall -n %s -l %s", condition, f.Name)
+			if f.Shorthand != "" {
+				fmt.Fprintf(b, " -s %s", f.Shorthand)
+			}
+			// flags without a default for their option value need an argument
+			if f.NoOptDefVal == "" {
+				b.WriteString(" -r")
+			}
+			fmt.Fprintf(b, " -d %s\n", fishQuote(f.Usage))
+		}
+	}
+}
+
+// writeCompletion writes the completion script for the shell.
+func writeCompletion(w io.Writer, shell string, r *commandRegistry) error {
+	var b bytes.Buffer
+	switch shell {
+	case "bash":
+		writeBashCompletion(&b, r)
+	case "zsh":
+		writeZshCompletion(&b, r)
+	case "fish":
+		writeFishCompletion(&b, r)
+	default:
+		return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", shell)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
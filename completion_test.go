@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestCompletion(t *testing.T) {
+	globalFlags := flag.NewFlagSet("ds-to-dhall", flag.ContinueOnError)
+	globalFlags.BoolP("quiet", "q", false, "only log errors")
+	r := newCommandRegistry(globalFlags)
+
+	if names := strings.Join(r.names(), " "); names != "ds2dhall dockerimg dhall2ds validate lint refs graph report help version completion" {
+		t.Errorf("unexpected command order %s", names)
+	}
+
+	expected := map[string][]string{
+		"bash": {
+			`" ") words="--quiet -q ds2dhall dockerimg dhall2ds validate lint refs graph report help version completion" ;;`,
+			`"dockerimg set") words="--dry-run --help -h --image -i --images" ;;`,
+			`"completion ") words="bash zsh fish" ;;`,
+		},
+		"zsh": {
+			`"dockerimg diff") candidates=(--format --help -h --on-conflict) ;;`,
+		},
+		"fish": {
+			`complete -c ds-to-dhall -n '__fish_seen_subcommand_from dockerimg; and __fish_seen_subcommand_from set' -l image -s i -r`,
+			`complete -c ds-to-dhall -n '__fish_seen_subcommand_from dockerimg; and not __fish_seen_subcommand_from set diff outdated' -l normalize -d`,
+			`-d 'extract the values matching this selector (e.g. \'*.Deployment.*.spec.replicas\')`,
+		},
+	}
+	for shell, lines := range expected {
+		var b bytes.Buffer
+		err := writeCompletion(&b, shell, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("expected the %s completion to contain %s", shell, line)
+			}
+		}
+	}
+
+	if err := writeCompletion(&bytes.Buffer{}, "powershell", r); err == nil {
+		t.Errorf("expected an error for an unknown shell")
+	}
+}
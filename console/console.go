@@ -0,0 +1,102 @@
+// Package console sets up the logging and progress output shared by all commands. Both go to stderr so stdout only
+// carries the results of a command.
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/inconshreveable/log15"
+	"github.com/inconshreveable/log15/term"
+	flag "github.com/spf13/pflag"
+)
+
+// spinnersEnabled is false if --quiet was given or stderr is not a terminal.
+var spinnersEnabled = true
+
+func parseLogFormat(s string) (log15.Format, error) {
+	switch s {
+	case "logfmt":
+		return log15.LogfmtFormat(), nil
+	case "json":
+		return log15.JsonFormat(), nil
+	case "human":
+		return log15.TerminalFormat(), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected logfmt, json or human", s)
+}
+
+// Setup installs the root log handler writing to stderr. quiet only logs errors and disables the spinners.
+func Setup(level string, format string, quiet bool) error {
+	lvl, err := log15.LvlFromString(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	if quiet {
+		lvl = log15.LvlError
+	}
+	logFormat, err := parseLogFormat(format)
+	if err != nil {
+		return err
+	}
+
+	log15.Root().SetHandler(log15.LvlFilterHandler(lvl, log15.StreamHandler(os.Stderr, logFormat)))
+	spinnersEnabled = !quiet && term.IsTty(os.Stderr.Fd())
+	return nil
+}
+
+// Spinner shows progress on stderr while a long running step is going on. It does nothing if spinners are disabled.
+type Spinner struct {
+	spin *spinner.Spinner
+}
+
+// NewSpinner returns a spinner showing prefix in front of the animation.
+func NewSpinner(prefix string) *Spinner {
+	if !spinnersEnabled {
+		return &Spinner{}
+	}
+	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
+	spin.Prefix = prefix
+	return &Spinner{spin: spin}
+}
+
+func (s *Spinner) Start() {
+	if s.spin != nil {
+		s.spin.Start()
+	}
+}
+
+func (s *Spinner) Stop() {
+	if s.spin != nil {
+		s.spin.Stop()
+	}
+}
+
+var (
+	usageMu sync.Mutex
+	// usageOutputs are the writers given to SetUsageOutput, pflag has no getter for the output of a flag set
+	usageOutputs = make(map[*flag.FlagSet]io.Writer)
+)
+
+// SetUsageOutput sends the usage of the flag set to w, both the option defaults and the lines its Usage function
+// writes through UsageOutput.
+func SetUsageOutput(flagSet *flag.FlagSet, w io.Writer) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	flagSet.SetOutput(w)
+	usageOutputs[flagSet] = w
+}
+
+// UsageOutput returns the writer the usage of the flag set goes to, stderr unless SetUsageOutput chose another one.
+func UsageOutput(flagSet *flag.FlagSet) io.Writer {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if w, ok := usageOutputs[flagSet]; ok {
+		return w
+	}
+	return os.Stderr
+}
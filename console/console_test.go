@@ -0,0 +1,24 @@
+package console
+
+import "testing"
+
+func TestSetup(t *testing.T) {
+	for _, format := range []string{"logfmt", "json", "human"} {
+		if err := Setup("debug", format, false); err != nil {
+			t.Errorf("format %s: %v", format, err)
+		}
+	}
+	if err := Setup("debug", "xml", false); err == nil {
+		t.Errorf("expected an error for an unknown log format")
+	}
+	if err := Setup("verbose", "logfmt", false); err == nil {
+		t.Errorf("expected an error for an unknown log level")
+	}
+
+	if err := Setup("debug", "logfmt", true); err != nil {
+		t.Fatal(err)
+	}
+	if spinnersEnabled {
+		t.Errorf("expected --quiet to disable the spinners")
+	}
+}
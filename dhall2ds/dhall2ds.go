@@ -12,7 +12,9 @@ import (
 	"text/tabwriter"
 	"time"
 
-	"github.com/briandowns/spinner"
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/console"
+	"ds-to-dhall/dockerimg"
 	"github.com/inconshreveable/log15"
 	gitignore "github.com/sabhiram/go-gitignore"
 	flag "github.com/spf13/pflag"
@@ -29,6 +31,8 @@ var (
 	ignore                   []string
 	generatedComment         bool
 	numConcurrentYAMLExports int
+	mirrors                  []string
+	mirrorFile               string
 
 	printHelp bool
 
@@ -51,7 +55,8 @@ func usageArgs() string {
 	return fmt.Sprintf("ARGS:\n%s", b.String())
 }
 
-func Main(args []string, mainCtx context.Context) {
+// Flags returns the flag set of dhall2ds, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
 	flagSet = flag.NewFlagSet("dhall2ds", flag.ExitOnError)
 
 	flagSet.StringVarP(&destinationPath, "output", "o", "", "(required) path to a destination directory")
@@ -60,16 +65,24 @@ func Main(args []string, mainCtx context.Context) {
 	flagSet.BoolVar(&generatedComment, "generated-comment", false, "Include a comment header in the generated YAML warning not to edit the generated files")
 	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
 	flagSet.IntVar(&numConcurrentYAMLExports, "numSimultaneousExports", 5, "how many simultaneous exports can happen")
+	flagSet.StringArrayVar(&mirrors, "mirror", nil,
+		"point the container images of a registry or registry/name prefix to a mirror, like docker.io/sourcegraph=registry.example.com/sourcegraph")
+	flagSet.StringVar(&mirrorFile, "mirror-file", "", "YAML file mapping sources to mirrors, in addition to --mirror")
 
 	flagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "dhall2ds %s\n", ShortDescription)
-		fmt.Fprintf(os.Stderr, "Usage of ds-to-dhall dhall2ds: --output <output> <path>\n")
-		fmt.Fprintln(os.Stderr, "OPTIONS:")
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "dhall2ds %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall dhall2ds: --output <output> <path>\n")
+		fmt.Fprintln(w, "OPTIONS:")
 		flagSet.PrintDefaults()
-		fmt.Fprintln(os.Stderr, usageArgs())
+		fmt.Fprintln(w, usageArgs())
 	}
 
-	_ = flagSet.Parse(args)
+	return flagSet
+}
+
+func Main(args []string, mainCtx context.Context) {
+	_ = Flags().Parse(args)
 
 	if printHelp {
 		flagSet.Usage()
@@ -81,7 +94,18 @@ func Main(args []string, mainCtx context.Context) {
 		os.Exit(1)
 	}
 
-	err := os.MkdirAll(destinationPath, 0777)
+	mirrorMap, err := dockerimg.ParseMirrors(mirrors)
+	if err != nil {
+		logFatal("invalid --mirror", "err", err)
+	}
+	if mirrorFile != "" {
+		err = mirrorMap.LoadMirrorFile(mirrorFile)
+		if err != nil {
+			logFatal("failed to load mirror file", "err", err)
+		}
+	}
+
+	err = os.MkdirAll(destinationPath, 0777)
 	if err != nil {
 		logFatal("cannot create output directory", "err", err, "output dir", destinationPath)
 	}
@@ -100,6 +124,11 @@ func Main(args []string, mainCtx context.Context) {
 		logFatal("failed to execute dhall-to-yaml", "error", err)
 	}
 
+	if !mirrorMap.Empty() {
+		n := rewriteImages(componentTree, mirrorMap)
+		log15.Info("pointed images to mirrors", "images", n)
+	}
+
 	err = exportComponents(componentTree, destinationPath, ignore)
 
 	if err != nil {
@@ -108,8 +137,7 @@ func Main(args []string, mainCtx context.Context) {
 }
 
 func dhallToYAML(ctx context.Context, dhallFile string) (map[string]interface{}, error) {
-	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-	spin.Prefix = "Running dhall-to-yaml: "
+	spin := console.NewSpinner("Running dhall-to-yaml: ")
 	spin.Start()
 	defer spin.Stop()
 
@@ -167,6 +195,49 @@ func (c *commandError) Error() string {
 	}, "\n")
 }
 
+// LoadResourceSet evaluates a COMKIR Dhall record with dhall-to-yaml and returns its resources. The source of every
+// resource is the Dhall file.
+func LoadResourceSet(ctx context.Context, dhallFile string) (*comkir.ResourceSet, error) {
+	componentTree, err := dhallToYAML(ctx, dhallFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &comkir.ResourceSet{Root: dhallFile, Components: make(map[string][]*comkir.Resource)}
+	for componentName, component := range componentTree {
+		componentMap, ok := component.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("component value for %s is not a record", componentName)
+		}
+
+		for kindName, kind := range componentMap {
+			kindMap, ok := kind.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("kind value for %s.%s is not a record", componentName, kindName)
+			}
+
+			for resourceName, resource := range kindMap {
+				resourceMap, ok := resource.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("resource value for %s.%s.%s is not a record",
+						componentName, kindName, resourceName)
+				}
+
+				apiVersion, _ := resourceMap["apiVersion"].(string)
+				rs.Components[componentName] = append(rs.Components[componentName], &comkir.Resource{
+					Source:     dhallFile,
+					Component:  componentName,
+					Kind:       kindName,
+					ApiVersion: apiVersion,
+					Name:       resourceName,
+					Contents:   resourceMap,
+				})
+			}
+		}
+	}
+	return rs, nil
+}
+
 func exportYAML(contents map[string]interface{}, destinationPath string, generatedComment bool) error {
 	yamlBytes, err := yaml.Marshal(contents)
 	if err != nil {
@@ -210,8 +281,7 @@ func exportComponents(componentTree map[string]interface{}, destinationPath stri
 
 	errs := new(errgroup.Group)
 
-	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-	spin.Prefix = fmt.Sprintf("Writing YAML to %q: ", destinationPath)
+	spin := console.NewSpinner(fmt.Sprintf("Writing YAML to %q: ", destinationPath))
 	spin.Start()
 	defer spin.Stop()
 
@@ -0,0 +1,43 @@
+package dhall2ds
+
+import (
+	"ds-to-dhall/dockerimg"
+	"github.com/inconshreveable/log15"
+)
+
+// rewriteImages points the container and init container images of the resources in v to their mirrors and returns
+// how many images were rewritten.
+func rewriteImages(v interface{}, mirrors *dockerimg.MirrorMap) int {
+	n := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			containers, ok := value.([]interface{})
+			if !ok || (key != "containers" && key != "initContainers") {
+				n += rewriteImages(value, mirrors)
+				continue
+			}
+
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				image, ok := container["image"].(string)
+				if !ok {
+					continue
+				}
+				if mirrored, ok := mirrors.RewriteString(image); ok {
+					log15.Debug("mirrored image", "image", image, "mirror", mirrored)
+					container["image"] = mirrored
+					n++
+				}
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			n += rewriteImages(item, mirrors)
+		}
+	}
+	return n
+}
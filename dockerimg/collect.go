@@ -0,0 +1,249 @@
+package dockerimg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+)
+
+// Location is a place in an input where an image reference was found.
+type Location struct {
+	File string `json:"file" yaml:"file"`
+	Line int    `json:"line" yaml:"line"`
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+type conflictPolicy string
+
+const (
+	// conflictFail fails if an image is used with different registries, tags or digests
+	conflictFail conflictPolicy = "fail"
+	// conflictHighest picks the variant with the highest semver tag
+	conflictHighest conflictPolicy = "highest"
+	// conflictFirst picks the variant found first
+	conflictFirst conflictPolicy = "first"
+	// conflictDistinct keeps every variant under its own key
+	conflictDistinct conflictPolicy = "distinct"
+)
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(s); p {
+	case conflictFail, conflictHighest, conflictFirst, conflictDistinct:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q", s)
+}
+
+// variant is one spelling of an image, with all the places it was found at.
+type variant struct {
+	imgRef    *ImageReference
+	locations []Location
+}
+
+func (v *variant) matches(imgRef *ImageReference) bool {
+	return v.imgRef.Registry == imgRef.Registry && v.imgRef.Version == imgRef.Version &&
+		v.imgRef.Sha256 == imgRef.Sha256
+}
+
+func (v *variant) String() string {
+	locations := make([]string, 0, len(v.locations))
+	for _, l := range v.locations {
+		locations = append(locations, l.String())
+	}
+	return fmt.Sprintf("%s (%s)", v.imgRef.String(), strings.Join(locations, ", "))
+}
+
+// collector gathers the image references found in the inputs, keeping every variant of an image instead of
+// silently dropping all but the first.
+type collector struct {
+	// keys are the image keys in the order they were first found in
+	keys     []string
+	variants map[string][]*variant
+
+	// normalize turns the images into their familiar form before they are collected
+	normalize bool
+}
+
+func newCollector() *collector {
+	return &collector{variants: make(map[string][]*variant)}
+}
+
+func (c *collector) add(imgRef *ImageReference, location Location) {
+	vs, ok := c.variants[imgRef.Key]
+	if !ok {
+		c.keys = append(c.keys, imgRef.Key)
+	}
+
+	for _, v := range vs {
+		if v.matches(imgRef) {
+			v.locations = append(v.locations, location)
+			return
+		}
+	}
+	c.variants[imgRef.Key] = append(vs, &variant{imgRef: imgRef, locations: []Location{location}})
+}
+
+// conflictError lists every variant of the images that are used with different registries, tags or digests.
+type conflictError struct {
+	conflicts [][]*variant
+}
+
+func (e *conflictError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d images are used with different registries, tags or digests:", len(e.conflicts))
+	for _, vs := range e.conflicts {
+		fmt.Fprintf(&b, "\n  %s", vs[0].imgRef.Key)
+		for _, v := range vs {
+			fmt.Fprintf(&b, "\n    %s", v)
+		}
+	}
+	return b.String()
+}
+
+// imageReferences returns the collected images in the order they were first found in, resolving conflicting
+// variants of an image according to the policy. Every image carries the locations of its variant.
+func (c *collector) imageReferences(policy conflictPolicy) ([]*ImageReference, error) {
+	var imgRefs []*ImageReference
+	var conflicts [][]*variant
+
+	for _, key := range c.keys {
+		vs := c.variants[key]
+		for _, v := range vs {
+			v.imgRef.Locations = v.locations
+		}
+		if len(vs) == 1 {
+			imgRefs = append(imgRefs, vs[0].imgRef)
+			continue
+		}
+		conflicts = append(conflicts, vs)
+
+		switch policy {
+		case conflictFail:
+		case conflictHighest:
+			picked := highestVariant(vs)
+			logConflict("picking the highest tag", vs, picked)
+			imgRefs = append(imgRefs, picked.imgRef)
+		case conflictDistinct:
+			logConflict("keeping every variant", vs, nil)
+			imgRefs = append(imgRefs, distinctImageReferences(vs)...)
+		default:
+			logConflict("picking the first variant, use --on-conflict to choose another policy", vs, vs[0])
+			imgRefs = append(imgRefs, vs[0].imgRef)
+		}
+	}
+
+	if policy == conflictFail && len(conflicts) > 0 {
+		return nil, &conflictError{conflicts: conflicts}
+	}
+	return imgRefs, nil
+}
+
+// variantImages returns every variant of every collected image with its locations, in the order they were found in.
+func (c *collector) variantImages() []*ImageReference {
+	var imgRefs []*ImageReference
+	for _, key := range c.keys {
+		for _, v := range c.variants[key] {
+			v.imgRef.Locations = v.locations
+			imgRefs = append(imgRefs, v.imgRef)
+		}
+	}
+	return imgRefs
+}
+
+func logConflict(message string, vs []*variant, picked *variant) {
+	ctx := []interface{}{"image", vs[0].imgRef.Key}
+	if picked != nil {
+		ctx = append(ctx, "picked", picked.imgRef.String())
+	}
+	for i, v := range vs {
+		ctx = append(ctx, fmt.Sprintf("variant%d", i+1), v.String())
+	}
+	log15.Warn("image is used with different registries, tags or digests, "+message, ctx...)
+}
+
+// highestVariant returns the variant with the highest semver tag. Tags that are not semver versions rank below all
+// that are, ties are won by the variant found first.
+func highestVariant(vs []*variant) *variant {
+	picked := vs[0]
+	pickedVersion, pickedOk := parseSemver(picked.imgRef.Version)
+	for _, v := range vs[1:] {
+		version, ok := parseSemver(v.imgRef.Version)
+		if !ok {
+			continue
+		}
+		if !pickedOk || version.compare(pickedVersion) > 0 {
+			picked, pickedVersion, pickedOk = v, version, true
+		}
+	}
+	return picked
+}
+
+// distinctImageReferences gives every variant a key of its own.
+func distinctImageReferences(vs []*variant) []*ImageReference {
+	imgRefs := make([]*ImageReference, 0, len(vs))
+	for _, v := range vs {
+		imgRefs = append(imgRefs, v.imgRef)
+	}
+	return DistinctImageReferences(imgRefs)
+}
+
+// DistinctImageReferences returns copies of the variants of an image, which share a key, with keys of their own made
+// of the name and as much of the tag, digest and registry as it takes to tell the variants apart.
+func DistinctImageReferences(variants []*ImageReference) []*ImageReference {
+	keyers := []func(*ImageReference) string{
+		func(r *ImageReference) string {
+			return r.Name + tagSuffix(r.Version)
+		},
+		func(r *ImageReference) string {
+			return r.Name + tagSuffix(r.Version) + shortDigest(r.Sha256)
+		},
+		func(r *ImageReference) string {
+			return strings.TrimPrefix(r.Registry+"/", "/") + r.Name + tagSuffix(r.Version) + shortDigest(r.Sha256)
+		},
+	}
+
+	var keys []string
+	for _, keyer := range keyers {
+		keys = keys[:0]
+		seen := make(map[string]bool)
+		for _, v := range variants {
+			key := keyer(v)
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		if len(seen) == len(variants) {
+			break
+		}
+	}
+
+	imgRefs := make([]*ImageReference, 0, len(variants))
+	for i, v := range variants {
+		imgRef := *v
+		imgRef.Key = keys[i]
+		imgRefs = append(imgRefs, &imgRef)
+	}
+	return imgRefs
+}
+
+// tagSuffix returns a : followed by the tag, or nothing if there is no tag.
+func tagSuffix(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	return ":" + tag
+}
+
+// shortDigest returns an @ followed by the first 12 digits of the digest, or nothing if there is no digest.
+func shortDigest(d string) string {
+	if len(d) > 12 {
+		d = d[:12]
+	}
+	if d == "" {
+		return ""
+	}
+	return "@" + d
+}
@@ -0,0 +1,90 @@
+package dockerimg
+
+import (
+	"strings"
+	"testing"
+)
+
+func testCollector(t *testing.T, images ...string) *collector {
+	t.Helper()
+
+	c := newCollector()
+	for i, image := range images {
+		imgRef, err := ParseImageReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.add(imgRef, Location{File: "test.yaml", Line: i + 1})
+	}
+	return c
+}
+
+func formatReferences(imgRefs []*ImageReference) string {
+	var refs []string
+	for _, imgRef := range imgRefs {
+		refs = append(refs, imgRef.Key+"="+imgRef.String())
+	}
+	return strings.Join(refs, " ")
+}
+
+func TestConflictPolicies(t *testing.T) {
+	images := []string{
+		"sourcegraph/frontend:3.20.0",
+		"redis:5.0",
+		"sourcegraph/frontend:3.21.0-rc.1",
+		"sourcegraph/frontend:3.20.0",
+		"sourcegraph/frontend:insiders",
+	}
+
+	tests := []struct {
+		policy conflictPolicy
+		want   string
+	}{
+		{conflictFirst, "sourcegraph/frontend=sourcegraph/frontend:3.20.0 redis=redis:5.0"},
+		{conflictHighest, "sourcegraph/frontend=sourcegraph/frontend:3.21.0-rc.1 redis=redis:5.0"},
+		{conflictDistinct, "sourcegraph/frontend:3.20.0=sourcegraph/frontend:3.20.0 " +
+			"sourcegraph/frontend:3.21.0-rc.1=sourcegraph/frontend:3.21.0-rc.1 " +
+			"sourcegraph/frontend:insiders=sourcegraph/frontend:insiders redis=redis:5.0"},
+	}
+	for _, test := range tests {
+		imgRefs, err := testCollector(t, images...).imageReferences(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatReferences(imgRefs); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.policy, got, test.want)
+		}
+	}
+
+	_, err := testCollector(t, images...).imageReferences(conflictFail)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+	want := `1 images are used with different registries, tags or digests:
+  sourcegraph/frontend
+    sourcegraph/frontend:3.20.0 (test.yaml:1, test.yaml:4)
+    sourcegraph/frontend:3.21.0-rc.1 (test.yaml:3)
+    sourcegraph/frontend:insiders (test.yaml:5)`
+	if err.Error() != want {
+		t.Errorf("got error\n%s\nwant\n%s", err, want)
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0",
+		"v1.0.1", "1.2", "3.20.0", "3.100.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		v, ok := parseSemver(ordered[i])
+		w, wok := parseSemver(ordered[i+1])
+		if !ok || !wok {
+			t.Fatalf("failed to parse %s or %s", ordered[i], ordered[i+1])
+		}
+		if v.compare(w) != -1 || w.compare(v) != 1 {
+			t.Errorf("expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+
+	if _, ok := parseSemver("insiders"); ok {
+		t.Error("insiders should not parse as a semver version")
+	}
+}
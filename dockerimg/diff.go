@@ -0,0 +1,222 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"ds-to-dhall/console"
+	flag "github.com/spf13/pflag"
+)
+
+const diffShortDescription = "reports the images added, removed and retagged between two deploy trees"
+
+var (
+	diffFormat     string
+	diffOnConflict string
+
+	diffFlagSet *flag.FlagSet
+)
+
+func usageDiffArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<old>\t(required) file or directory of the old release")
+	fmt.Fprintln(w, "\t<new>\t(required) file or directory of the new release")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+// imageChange is an image whose registry, tag or digest differs between two inventories.
+type imageChange struct {
+	Key string     `json:"key"`
+	Old imageEntry `json:"old"`
+	New imageEntry `json:"new"`
+}
+
+// inventoryDiff are the differences between two image inventories, with the changed images ordered by key.
+type inventoryDiff struct {
+	Added   map[string]imageEntry `json:"added"`
+	Removed map[string]imageEntry `json:"removed"`
+	Changed []imageChange         `json:"changed"`
+}
+
+func (d *inventoryDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func diffInventories(old []*ImageReference, new []*ImageReference) *inventoryDiff {
+	oldEntries, newEntries := imageEntries(old), imageEntries(new)
+	d := &inventoryDiff{
+		Added:   make(map[string]imageEntry),
+		Removed: make(map[string]imageEntry),
+		Changed: []imageChange{},
+	}
+
+	for key, o := range oldEntries {
+		n, ok := newEntries[key]
+		switch {
+		case !ok:
+			d.Removed[key] = o
+		case !o.sameImage(n):
+			d.Changed = append(d.Changed, imageChange{Key: key, Old: o, New: n})
+		}
+	}
+	for key, n := range newEntries {
+		if _, ok := oldEntries[key]; !ok {
+			d.Added[key] = n
+		}
+	}
+	d.pairRetags()
+
+	sort.Slice(d.Changed, func(i, j int) bool {
+		if d.Changed[i].Key != d.Changed[j].Key {
+			return d.Changed[i].Key < d.Changed[j].Key
+		}
+		return d.Changed[i].Old.reference() < d.Changed[j].Old.reference()
+	})
+	return d
+}
+
+// pairRetags turns images of a repository that were removed and added under different keys into changes. With
+// --on-conflict distinct the keys of the variants of an image include their tags, so a retag changes the key.
+func (d *inventoryDiff) pairRetags() {
+	removed, added := make(map[string][]string), make(map[string][]string)
+	for _, key := range sortedKeys(d.Removed) {
+		name := d.Removed[key].Name
+		removed[name] = append(removed[name], key)
+	}
+	for _, key := range sortedKeys(d.Added) {
+		name := d.Added[key].Name
+		added[name] = append(added[name], key)
+	}
+
+	for name, oldKeys := range removed {
+		newKeys := added[name]
+		for i := 0; i < len(oldKeys) && i < len(newKeys); i++ {
+			d.Changed = append(d.Changed, imageChange{Key: name, Old: d.Removed[oldKeys[i]], New: d.Added[newKeys[i]]})
+			delete(d.Removed, oldKeys[i])
+			delete(d.Added, newKeys[i])
+		}
+	}
+}
+
+// reference formats the entry as registry/name:tag@sha256:digest.
+func (e imageEntry) reference() string {
+	imgRef := ImageReference{Registry: e.Registry, Name: e.Name, Version: e.Tag, Sha256: e.Digest}
+	return imgRef.String()
+}
+
+func sortedKeys(entries map[string]imageEntry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeDiffText(w io.Writer, d *inventoryDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+
+	if len(d.Added) > 0 {
+		fmt.Fprintln(tw, "added:")
+		for _, key := range sortedKeys(d.Added) {
+			fmt.Fprintf(tw, "  %s\t%s\n", key, d.Added[key].reference())
+		}
+	}
+	if len(d.Removed) > 0 {
+		fmt.Fprintln(tw, "removed:")
+		for _, key := range sortedKeys(d.Removed) {
+			fmt.Fprintf(tw, "  %s\t%s\n", key, d.Removed[key].reference())
+		}
+	}
+	if len(d.Changed) > 0 {
+		fmt.Fprintln(tw, "changed:")
+		for _, c := range d.Changed {
+			fmt.Fprintf(tw, "  %s\t%s\t->\t%s\n", c.Key, c.Old.reference(), c.New.reference())
+		}
+	}
+	if d.empty() {
+		fmt.Fprintln(tw, "no images changed")
+	}
+
+	return tw.Flush()
+}
+
+// inventory scans the input for images like dockerimg does.
+func inventory(input string, policy conflictPolicy) ([]*ImageReference, error) {
+	c := newCollector()
+	err := processInputs([]string{input}, c)
+	if err != nil {
+		return nil, err
+	}
+	return c.imageReferences(policy)
+}
+
+func diffFlags() *flag.FlagSet {
+	diffFlagSet = flag.NewFlagSet("dockerimg diff", flag.ExitOnError)
+
+	diffFlagSet.StringVar(&diffFormat, "format", "text", "output format: text or json")
+	diffFlagSet.StringVar(&diffOnConflict, "on-conflict", string(conflictFirst),
+		"what to do if an image is used with different registries, tags or digests in one tree: fail, highest, first or distinct")
+	diffFlagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	diffFlagSet.Usage = func() {
+		w := console.UsageOutput(diffFlagSet)
+		fmt.Fprintf(w, "dockerimg diff %s\n", diffShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall dockerimg diff: <old> <new>\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		diffFlagSet.PrintDefaults()
+		fmt.Fprintln(w, usageDiffArgs())
+	}
+
+	return diffFlagSet
+}
+
+func diffMain(args []string, ctx context.Context) {
+	_ = diffFlags().Parse(args)
+
+	if printHelp {
+		diffFlagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(diffFlagSet.Args()) != 2 || (diffFormat != "text" && diffFormat != "json") {
+		diffFlagSet.Usage()
+		os.Exit(1)
+	}
+
+	policy, err := parseConflictPolicy(diffOnConflict)
+	if err != nil {
+		logFatal("invalid --on-conflict", "err", err)
+	}
+
+	old, err := inventory(diffFlagSet.Arg(0), policy)
+	if err != nil {
+		logFatal("failed to process old release", "err", err)
+	}
+	new, err := inventory(diffFlagSet.Arg(1), policy)
+	if err != nil {
+		logFatal("failed to process new release", "err", err)
+	}
+
+	d := diffInventories(old, new)
+	if diffFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(d)
+	} else {
+		err = writeDiffText(os.Stdout, d)
+	}
+	if err != nil {
+		logFatal("failed to write diff", "err", err)
+	}
+}
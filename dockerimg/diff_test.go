@@ -0,0 +1,102 @@
+package dockerimg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffInventories(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	oldFile := write("old.yaml", `containers:
+- image: sourcegraph/frontend:3.20.0
+- image: redis:5.0
+- image: sourcegraph/syntect:3.20.0
+`)
+	newFile := write("new.yaml", `containers:
+- image: sourcegraph/frontend:3.21.0@`+testDigest+`
+- image: redis:5.0
+- image: sourcegraph/searcher:3.21.0
+`)
+
+	old, err := inventory(oldFile, conflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := inventory(newFile, conflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = writeDiffText(&b, diffInventories(old, new))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `added:
+  sourcegraph/searcher sourcegraph/searcher:3.21.0
+removed:
+  sourcegraph/syntect sourcegraph/syntect:3.20.0
+changed:
+  sourcegraph/frontend sourcegraph/frontend:3.20.0 -> sourcegraph/frontend:3.21.0@` + testDigest + `
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestDiffInventoriesDistinct(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// the variants of frontend get keys with their tags, the retag from 3.20.0 to 3.21.0 is still a change
+	oldFile := write("old.yaml", `containers:
+- image: sourcegraph/frontend:3.20.0
+- image: sourcegraph/frontend:3.19.0
+- image: sourcegraph/syntect:3.20.0
+`)
+	newFile := write("new.yaml", `containers:
+- image: sourcegraph/frontend:3.21.0
+- image: sourcegraph/frontend:3.19.0
+`)
+
+	old, err := inventory(oldFile, conflictDistinct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := inventory(newFile, conflictDistinct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = writeDiffText(&b, diffInventories(old, new))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `removed:
+  sourcegraph/syntect sourcegraph/syntect:3.20.0
+changed:
+  sourcegraph/frontend sourcegraph/frontend:3.20.0 -> sourcegraph/frontend:3.21.0
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
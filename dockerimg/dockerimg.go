@@ -13,6 +13,7 @@ import (
 	"text/tabwriter"
 	"text/template"
 
+	"ds-to-dhall/console"
 	"github.com/inconshreveable/log15"
 	flag "github.com/spf13/pflag"
 )
@@ -34,7 +35,33 @@ func usageArgs() string {
 	return fmt.Sprintf("ARGS:\n%s", b.String())
 }
 
+func usageSubcommands() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	for _, sub := range Subcommands() {
+		fmt.Fprintf(w, "\t%s\t%s\n", sub.Name, sub.ShortDescription)
+	}
+	w.Flush()
+
+	return fmt.Sprintf("SUBCOMMANDS:\n%s", b.String())
+}
+
 var (
+	inputFormatName  string
+	onConflict       string
+	normalize        bool
+	policyFile       string
+	mirrors          []string
+	mirrorFile       string
+	mirrorList       string
+	format           string
+	templateFile     string
+	outputFile       string
+	resolve          bool
+	credentialsFile  string
+	insecureRegistry []string
+
 	printHelp bool
 
 	flagSet *flag.FlagSet
@@ -46,12 +73,33 @@ type ImageReference struct {
 	Version  string
 	Sha256   string
 	Key      string
+	// Locations are the places in the inputs the image was found at
+	Locations []Location
+}
+
+// String formats the image as registry/name:tag@sha256:digest.
+func (ir *ImageReference) String() string {
+	s := ir.Name
+	if ir.Version != "" {
+		s += ":" + ir.Version
+	}
+	if ir.Registry != "" {
+		s = ir.Registry + "/" + s
+	}
+	if ir.Sha256 != "" {
+		s += "@sha256:" + ir.Sha256
+	}
+	return s
 }
 
 func (ir *ImageReference) FormatRegistry() string {
 	return ir.formatOptionalText(ir.Registry)
 }
 
+func (ir *ImageReference) FormatTag() string {
+	return ir.formatOptionalText(ir.Version)
+}
+
 func (ir *ImageReference) FormatDigest() string {
 	return ir.formatOptionalText(ir.Sha256)
 }
@@ -79,73 +127,124 @@ func domainIsNotHostName(s string) bool {
 	return s != "" && !hasPort.MatchString(s) && !hasDot.MatchString(s) && !isLocalhost.MatchString(s)
 }
 
-func processReader(ir io.Reader, imgRefs *[]*ImageReference, seen map[string]struct{}) error {
-	contents, err := ioutil.ReadAll(ir)
+// ParseImageReference parses a docker image reference like sourcegraph/frontend:3.20@sha256:<digest>. The
+// reference must have a name, the tag and digest are optional.
+func ParseImageReference(s string) (*ImageReference, error) {
+	r, err := Parse(s)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, line := range strings.Split(string(contents), "\n") {
-		for _, p := range []string{"-", "image:"} {
-			line = strings.TrimSpace(line)
-			line = strings.TrimPrefix(line, p)
-			line = strings.TrimSpace(line)
-		}
+	imgRef := &ImageReference{}
+	named, ok := r.(Named)
+	if !ok {
+		return nil, fmt.Errorf("image reference %s has no name", s)
+	}
 
-		if line == "" {
-			continue
-		}
+	path := Path(named)
 
-		r, err := Parse(line)
-		if err != nil {
-			// silently skip over any parse errors (for instance - the line isn't something that contains a docker reference)
-			continue
-		}
+	imgRef.Name = path
 
-		imgRef := &ImageReference{}
-		named, ok := r.(Named)
-		if !ok {
-			// must have a name at least
-			continue
-		}
+	d := Domain(named)
+	imgRef.Registry = d
 
-		tagged, ok := r.(Tagged)
-		if !ok {
-			// must have a tag at least
-			continue
-		}
+	if domainIsNotHostName(d) {
+		imgRef.Name = fmt.Sprintf("%s/%s", d, path)
+		imgRef.Registry = ""
+	}
 
-		path := Path(named)
+	imgRef.Key = imgRef.Name
 
-		imgRef.Name = path
+	if tagged, ok := r.(Tagged); ok {
+		imgRef.Version = tagged.Tag()
+	}
 
-		d := Domain(named)
-		imgRef.Registry = d
+	if digested, ok := r.(Digested); ok {
+		imgRef.Sha256 = strings.TrimPrefix(digested.Digest().String(), "sha256:")
+	}
 
-		if domainIsNotHostName(d) {
-			imgRef.Name = fmt.Sprintf("%s/%s", d, path)
-			imgRef.Registry = ""
-		}
+	return imgRef, nil
+}
 
-		imgRef.Key = imgRef.Name
+// NormalizeImageReference returns the image with its name in the familiar form of the docker CLI, so that redis,
+// docker.io/library/redis and index.docker.io/redis all become redis without a registry.
+func NormalizeImageReference(imgRef *ImageReference) (*ImageReference, error) {
+	named, err := ParseNormalizedNamed(canonicalPath(imgRef.Registry, imgRef.Name))
+	if err != nil {
+		return nil, err
+	}
 
-		imgRef.Version = tagged.Tag()
+	normalized := *imgRef
+	normalized.Registry, normalized.Name = Domain(named), Path(named)
+	if normalized.Registry == defaultDomain {
+		normalized.Registry, normalized.Name = "", FamiliarName(named)
+	}
+	normalized.Key = normalized.Name
+	return &normalized, nil
+}
 
-		if digested, ok := r.(Digested); ok {
-			imgRef.Sha256 = strings.TrimPrefix(digested.Digest().String(), "sha256:")
-		}
+// unversioned reports whether the image has neither a tag nor a digest.
+func (ir *ImageReference) unversioned() bool {
+	return ir.Version == "" && ir.Sha256 == ""
+}
+
+// reference parses the image reference of the hit. References without tag and digest are only accepted from the
+// image fields of YAML and Dhall files, anywhere else they are too likely to be some other word.
+func (h imageHit) reference() (*ImageReference, bool) {
+	if !h.accepted() {
+		return nil, false
+	}
+	imgRef, err := ParseImageReference(h.value)
+	if err != nil {
+		// silently skip over any parse errors (for instance - the value isn't a docker reference)
+		return nil, false
+	}
+	if imgRef.unversioned() && (!h.strong || h.context == lineContext) {
+		return nil, false
+	}
+	return imgRef, true
+}
+
+// processReader scans the input in the given format for image references. source names the input in log messages.
+func processReader(ir io.Reader, source string, format inputFormat, c *collector) error {
+	contents, err := ioutil.ReadAll(ir)
+	if err != nil {
+		return err
+	}
 
-		if _, ok := seen[imgRef.Key]; !ok {
-			*imgRefs = append(*imgRefs, imgRef)
-			seen[imgRef.Key] = struct{}{}
+	for _, hit := range scan(contents, format) {
+		imgRef, ok := hit.reference()
+		if !ok {
+			continue
 		}
+		if c.normalize {
+			imgRef, err = NormalizeImageReference(imgRef)
+			if err != nil {
+				log15.Warn("failed to normalize image", "image", hit.value, "source", source, "line", hit.line,
+					"err", err)
+				continue
+			}
+		}
+		log15.Debug("found image", "image", hit.value, "source", source, "line", hit.line, "context", hit.context)
 
+		c.add(imgRef, Location{File: source, Line: hit.line})
 	}
 
 	return nil
 }
 
-func processFile(path string, imgRefs *[]*ImageReference, seen map[string]struct{}) error {
+// fileFormat returns the input format of a file based on its extension.
+func fileFormat(path string) inputFormat {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".dhall":
+		return formatDhall
+	}
+	return formatLines
+}
+
+func processFile(path string, c *collector) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
@@ -154,14 +253,21 @@ func processFile(path string, imgRefs *[]*ImageReference, seen map[string]struct
 
 	bf := bufio.NewReader(f)
 
-	err = processReader(bf, imgRefs, seen)
+	err = processReader(bf, path, fileFormat(path), c)
 	if err != nil {
 		return fmt.Errorf("error on file %s: %w", path, err)
 	}
 	return nil
 }
 
-func processInputs(inputs []string, imgRefs *[]*ImageReference, seen map[string]struct{}) error {
+func processInputs(inputs []string, c *collector) error {
+	return walkInputs(inputs, func(path string) error {
+		return processFile(path, c)
+	})
+}
+
+// walkInputs calls fn for every YAML and Dhall file in the input files and directories.
+func walkInputs(inputs []string, fn func(path string) error) error {
 	for _, input := range inputs {
 		err := filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -173,7 +279,7 @@ func processInputs(inputs []string, imgRefs *[]*ImageReference, seen map[string]
 			}
 
 			if filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" || filepath.Ext(path) == ".dhall" {
-				return processFile(path, imgRefs, seen)
+				return fn(path)
 			}
 			return nil
 		})
@@ -186,10 +292,11 @@ func processInputs(inputs []string, imgRefs *[]*ImageReference, seen map[string]
 
 const imageRecordTemplate = `let images =
 {
-  {{range $index, $imgRef := .}} {{if gt $index 0}},{{end}} {{$imgRef.FormatKey}} = {
+  {{range $index, $imgRef := .}} {{if gt $index 0}},{{end}}{{range $imgRef.Locations}} -- {{.}}
+  {{end}} {{$imgRef.FormatKey}} = {
          registry = {{$imgRef.FormatRegistry}}
          , name = "{{$imgRef.Name}}"
-         , tag = "{{$imgRef.Version}}"
+         , tag = {{$imgRef.FormatTag}}
          , digest = {{$imgRef.FormatDigest}}
       }
   {{end}}
@@ -199,43 +306,208 @@ in images
 
 var tmpl = template.Must(template.New("imageRecordDhall").Parse(imageRecordTemplate))
 
-func Main(args []string, _ context.Context) {
+// WriteImagesRecord writes the Dhall images record for imgRefs to w.
+func WriteImagesRecord(w io.Writer, imgRefs []*ImageReference) error {
+	return tmpl.Execute(w, imgRefs)
+}
+
+// Flags returns the flag set of dockerimg, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
 	flagSet = flag.NewFlagSet("dockerimg", flag.ExitOnError)
 
+	flagSet.StringVar(&inputFormatName, "input-format", string(formatAuto),
+		"format of stdin: auto, yaml, dhall or lines. files are scanned according to their extension")
+	flagSet.StringVar(&onConflict, "on-conflict", string(conflictFirst),
+		"what to do if an image is used with different registries, tags or digests: fail, highest (semver tag), first or distinct (one key per variant)")
+	flagSet.StringVar(&policyFile, "policy", "",
+		"check the images against the rules in this YAML file instead of printing them. exits with status 1 on violations")
+	flagSet.BoolVar(&normalize, "normalize", false,
+		"use the familiar names of the docker CLI, so that docker.io/library/redis and redis are the same image")
+	flagSet.BoolVar(&resolve, "resolve-digests", false,
+		"look up the digest of every image without one through the registry v2 API")
+	flagSet.StringVar(&credentialsFile, "credentials", "",
+		"registry credentials in the format of the docker config.json file, used with --resolve-digests")
+	flagSet.StringArrayVar(&insecureRegistry, "insecure-registry", nil,
+		"access this registry over plain http. localhost registries always are")
+	flagSet.StringArrayVar(&mirrors, "mirror", nil,
+		"point the images of a registry or registry/name prefix to a mirror, like docker.io/sourcegraph=registry.example.com/sourcegraph")
+	flagSet.StringVar(&mirrorFile, "mirror-file", "", "YAML file mapping sources to mirrors, in addition to --mirror")
+	flagSet.StringVar(&mirrorList, "mirror-list", "",
+		"write a line with the source and mirror reference of every mirrored image to this file")
+	flagSet.StringVar(&format, "format", string(outputDhall),
+		"output format: dhall, dhall-typed (annotated with an Image type), json, yaml, csv or lines (registry/name:tag@digest)")
+	flagSet.StringVar(&templateFile, "template", "",
+		"render the images with this Go template instead of --format. it is executed with the list of images")
+	flagSet.StringVarP(&outputFile, "output", "o", "", "write the images to this file instead of stdout")
 	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
 
 	flagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "dockerimg %s\n", ShortDescription)
-		fmt.Fprintf(os.Stderr, "Usage of ds-to-dhall dockerimg: <path>\n")
-		fmt.Fprintln(os.Stderr, "OPTIONS:")
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "dockerimg %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall dockerimg: <path>\n")
+		fmt.Fprintln(w, "OPTIONS:")
 		flagSet.PrintDefaults()
-		fmt.Fprintln(os.Stderr, usageArgs())
+		fmt.Fprintln(w, usageArgs())
+		fmt.Fprintln(w, usageSubcommands())
+	}
+
+	return flagSet
+}
+
+// Subcommand is a command nested under dockerimg, like dockerimg set.
+type Subcommand struct {
+	Name             string
+	ShortDescription string
+	Main             func([]string, context.Context)
+	Flags            func() *flag.FlagSet
+}
+
+// Subcommands returns the subcommands of dockerimg, selected by the first argument.
+func Subcommands() []Subcommand {
+	return []Subcommand{
+		{Name: "set", ShortDescription: setShortDescription, Main: setMain, Flags: setFlags},
+		{Name: "diff", ShortDescription: diffShortDescription, Main: diffMain, Flags: diffFlags},
+		{Name: "outdated", ShortDescription: outdatedShortDescription, Main: outdatedMain, Flags: outdatedFlags},
+	}
+}
+
+func Main(args []string, ctx context.Context) {
+	if len(args) > 0 {
+		for _, sub := range Subcommands() {
+			if sub.Name == args[0] {
+				sub.Main(args[1:], ctx)
+				return
+			}
+		}
 	}
 
-	_ = flagSet.Parse(args)
+	_ = Flags().Parse(args)
 
 	if printHelp {
 		flagSet.Usage()
 		os.Exit(0)
 	}
 
-	var imgRefs []*ImageReference
-	seen := make(map[string]struct{})
+	outFormat, err := parseOutputFormat(format)
+	if err != nil {
+		logFatal("invalid --format", "err", err)
+	}
+	var userTemplate *template.Template
+	if templateFile != "" {
+		if flagSet.Changed("format") {
+			logFatal("--template and --format cannot be combined")
+		}
+		userTemplate, err = loadTemplate(templateFile)
+		if err != nil {
+			logFatal("failed to load template", "err", err)
+		}
+	}
+
+	policy, err := parseConflictPolicy(onConflict)
+	if err != nil {
+		logFatal("invalid --on-conflict", "err", err)
+	}
+
+	mirrorMap, err := ParseMirrors(mirrors)
+	if err != nil {
+		logFatal("invalid --mirror", "err", err)
+	}
+	if mirrorFile != "" {
+		err = mirrorMap.LoadMirrorFile(mirrorFile)
+		if err != nil {
+			logFatal("failed to load mirror file", "err", err)
+		}
+	}
+	if mirrorList != "" && mirrorMap.Empty() {
+		logFatal("--mirror-list requires --mirror or --mirror-file")
+	}
+
+	c := newCollector()
+	c.normalize = normalize
 
 	if len(flagSet.Args()) == 0 {
-		err := processReader(os.Stdin, &imgRefs, seen)
+		format, err := parseInputFormat(inputFormatName)
+		if err != nil {
+			logFatal("invalid --input-format", "err", err)
+		}
+
+		err = processReader(os.Stdin, "stdin", format, c)
 		if err != nil {
 			logFatal("failed to process from stdin", "err", err)
 		}
 	} else {
-		err := processInputs(flagSet.Args(), &imgRefs, seen)
+		err := processInputs(flagSet.Args(), c)
 		if err != nil {
 			logFatal("failed to process", "err", err)
 		}
 	}
 
-	err := tmpl.Execute(os.Stdout, imgRefs)
+	if policyFile != "" {
+		p, err := loadPolicy(policyFile)
+		if err != nil {
+			logFatal("failed to load policy", "err", err)
+		}
+
+		violations := c.checkPolicy(p)
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		if len(violations) > 0 {
+			logFatal("images violate the policy", "violations", len(violations))
+		}
+		return
+	}
+
+	imgRefs, err := c.imageReferences(policy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if resolve {
+		client, err := registryClientFromFlags()
+		if err != nil {
+			logFatal("failed to load credentials", "err", err)
+		}
+
+		err = resolveDigests(ctx, client, imgRefs)
+		if err != nil {
+			logFatal("failed to resolve digests", "err", err)
+		}
+	}
+
+	if !mirrorMap.Empty() {
+		var pairs [][2]string
+		imgRefs, pairs = mirrorImages(mirrorMap, imgRefs)
+
+		if mirrorList != "" {
+			var b bytes.Buffer
+			err = writeMirrorList(&b, pairs)
+			if err == nil {
+				err = ioutil.WriteFile(mirrorList, b.Bytes(), 0644)
+			}
+			if err != nil {
+				logFatal("failed to write mirror list", "err", err)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if userTemplate != nil {
+		err = userTemplate.Execute(&out, imgRefs)
+	} else {
+		err = writeImages(&out, outFormat, imgRefs)
+	}
+	if err != nil {
+		logFatal("failed to render images", "err", err)
+	}
+
+	if outputFile != "" {
+		err = ioutil.WriteFile(outputFile, out.Bytes(), 0644)
+	} else {
+		_, err = os.Stdout.Write(out.Bytes())
+	}
 	if err != nil {
-		logFatal("failed to write to stdout", "err", err)
+		logFatal("failed to write images", "err", err)
 	}
 }
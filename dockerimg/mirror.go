@@ -0,0 +1,205 @@
+package dockerimg
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type mirrorRule struct {
+	source string
+	mirror string
+}
+
+// MirrorMap maps registries or registry/name prefixes to mirrors, like docker.io/sourcegraph to
+// registry.example.com/sourcegraph. Images are rewritten by the rule with the longest matching prefix.
+type MirrorMap struct {
+	rules []mirrorRule
+}
+
+// hubAliases are the names of docker hub, images without a registry come from there as well.
+var hubAliases = map[string]bool{
+	"":                     true,
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// canonicalPath returns registry/name with the docker hub aliases replaced by docker.io.
+func canonicalPath(registry string, name string) string {
+	if hubAliases[registry] {
+		registry = "docker.io"
+	}
+	return registry + "/" + name
+}
+
+// ParseMirrors parses mirror rules of the form source=mirror.
+func ParseMirrors(specs []string) (*MirrorMap, error) {
+	m := &MirrorMap{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("mirror %q is not of the form source=mirror", spec)
+		}
+		err := m.add(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// LoadMirrorFile adds the rules of a YAML file mapping sources to mirrors to the map.
+func (m *MirrorMap) LoadMirrorFile(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var mirrors map[string]string
+	err = yaml.Unmarshal(contents, &mirrors)
+	if err != nil {
+		return fmt.Errorf("failed to parse mirror file %s: %w", file, err)
+	}
+
+	sources := make([]string, 0, len(mirrors))
+	for source := range mirrors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		err := m.add(source, mirrors[source])
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (m *MirrorMap) add(source string, mirror string) error {
+	source = strings.Trim(strings.TrimSpace(source), "/")
+	mirror = strings.Trim(strings.TrimSpace(mirror), "/")
+	if source == "" || mirror == "" {
+		return fmt.Errorf("mirror rule %q=%q has an empty side", source, mirror)
+	}
+
+	registry, name := source, ""
+	if i := strings.Index(source, "/"); i >= 0 {
+		registry, name = source[:i], source[i+1:]
+	}
+	source = strings.TrimSuffix(canonicalPath(registry, name), "/")
+
+	for _, r := range m.rules {
+		if r.source == source && r.mirror != mirror {
+			return fmt.Errorf("%s is mirrored to both %s and %s", source, r.mirror, mirror)
+		}
+	}
+	m.rules = append(m.rules, mirrorRule{source: source, mirror: mirror})
+
+	sort.SliceStable(m.rules, func(i, j int) bool {
+		return len(m.rules[i].source) > len(m.rules[j].source)
+	})
+	return nil
+}
+
+// Empty reports whether the map has no rules.
+func (m *MirrorMap) Empty() bool {
+	return len(m.rules) == 0
+}
+
+// imagePaths returns the paths rules are matched against, registry/name with the docker hub aliases replaced by
+// docker.io and, if it differs, the fully qualified name of ParseNormalizedNamed, which adds library/ to official
+// images.
+func imagePaths(imgRef *ImageReference) []string {
+	p := canonicalPath(imgRef.Registry, imgRef.Name)
+	named, err := ParseNormalizedNamed(p)
+	if err != nil || named.Name() == p {
+		return []string{p}
+	}
+	return []string{p, named.Name()}
+}
+
+// matchPath returns the first path that is source or below it.
+func matchPath(paths []string, source string) (string, bool) {
+	for _, p := range paths {
+		if p == source || strings.HasPrefix(p, source+"/") {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// Rewrite returns the image pointed to its mirror, with the same key, tag and digest. ok is false if no rule
+// matches the image.
+func (m *MirrorMap) Rewrite(imgRef *ImageReference) (*ImageReference, bool) {
+	paths := imagePaths(imgRef)
+	for _, r := range m.rules {
+		p, ok := matchPath(paths, r.source)
+		if !ok {
+			continue
+		}
+
+		rewritten := r.mirror + strings.TrimPrefix(p, r.source)
+		if !strings.Contains(rewritten, "/") {
+			// a whole image was mirrored to a registry, keep the last part of its name
+			rewritten += "/" + path.Base(imgRef.Name)
+		}
+
+		i := strings.Index(rewritten, "/")
+		mirrored := *imgRef
+		mirrored.Registry, mirrored.Name = rewritten[:i], rewritten[i+1:]
+		return &mirrored, true
+	}
+	return imgRef, false
+}
+
+// RewriteString rewrites an image reference like sourcegraph/frontend:3.20.0. References that cannot be parsed or
+// that no rule matches are returned as they are.
+func (m *MirrorMap) RewriteString(image string) (string, bool) {
+	imgRef, err := ParseImageReference(image)
+	if err != nil {
+		return image, false
+	}
+	mirrored, ok := m.Rewrite(imgRef)
+	if !ok {
+		return image, false
+	}
+	return mirrored.String(), true
+}
+
+// mirrorImages rewrites the images and returns the rewritten images along with the pairs of source and mirror
+// references of the images that were rewritten.
+func mirrorImages(m *MirrorMap, imgRefs []*ImageReference) ([]*ImageReference, [][2]string) {
+	mirrored := make([]*ImageReference, 0, len(imgRefs))
+	var pairs [][2]string
+	for _, imgRef := range imgRefs {
+		r, ok := m.Rewrite(imgRef)
+		if ok {
+			source := *imgRef
+			if hubAliases[source.Registry] {
+				source.Registry = "docker.io"
+			}
+			pairs = append(pairs, [2]string{source.String(), r.String()})
+		}
+		mirrored = append(mirrored, r)
+	}
+	return mirrored, pairs
+}
+
+// writeMirrorList writes a line with the source and mirror reference for every mirrored image, the input expected
+// by copy tools like "xargs -n 2 crane copy".
+func writeMirrorList(w io.Writer, pairs [][2]string) error {
+	for _, p := range pairs {
+		_, err := fmt.Fprintf(w, "%s %s\n", p[0], p[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
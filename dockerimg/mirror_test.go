@@ -0,0 +1,75 @@
+package dockerimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMirrorImages(t *testing.T) {
+	m, err := ParseMirrors([]string{
+		"docker.io=registry.example.com/hub",
+		"index.docker.io/sourcegraph=registry.example.com/sourcegraph",
+		"us.gcr.io/sourcegraph-dev/syntect=mirror.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var imgRefs []*ImageReference
+	for _, image := range []string{
+		"sourcegraph/frontend:3.20.0@sha256:" + testDigest[len("sha256:"):],
+		"redis:5.0",
+		"us.gcr.io/sourcegraph-dev/syntect:insiders",
+		"quay.io/prometheus/node-exporter:1.0.0",
+	} {
+		imgRef, err := ParseImageReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgRefs = append(imgRefs, imgRef)
+	}
+
+	mirrored, pairs := mirrorImages(m, imgRefs)
+
+	want := []string{
+		"sourcegraph/frontend=registry.example.com/sourcegraph/frontend:3.20.0@" + testDigest,
+		"redis=registry.example.com/hub/redis:5.0",
+		"sourcegraph-dev/syntect=mirror.example.com/syntect:insiders",
+		"prometheus/node-exporter=quay.io/prometheus/node-exporter:1.0.0",
+	}
+	for i, imgRef := range mirrored {
+		if got := imgRef.Key + "=" + imgRef.String(); got != want[i] {
+			t.Errorf("got %s, want %s", got, want[i])
+		}
+	}
+
+	var b bytes.Buffer
+	err = writeMirrorList(&b, pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantList := "docker.io/sourcegraph/frontend:3.20.0@" + testDigest +
+		" registry.example.com/sourcegraph/frontend:3.20.0@" + testDigest + "\n" +
+		"docker.io/redis:5.0 registry.example.com/hub/redis:5.0\n" +
+		"us.gcr.io/sourcegraph-dev/syntect:insiders mirror.example.com/syntect:insiders\n"
+	if b.String() != wantList {
+		t.Errorf("got mirror list\n%s\nwant\n%s", b.String(), wantList)
+	}
+}
+
+func TestMirrorOfficialImages(t *testing.T) {
+	m, err := ParseMirrors([]string{"docker.io/library=mirror.example.com/hub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, image := range []string{"redis:5.0", "docker.io/redis:5.0", "index.docker.io/library/redis:5.0"} {
+		got, ok := m.RewriteString(image)
+		if !ok || got != "mirror.example.com/hub/redis:5.0" {
+			t.Errorf("expected %s to be mirrored to mirror.example.com/hub/redis:5.0, got %s", image, got)
+		}
+	}
+	if got, ok := m.RewriteString("sourcegraph/frontend:3.20.0"); ok {
+		t.Errorf("expected sourcegraph/frontend not to be mirrored, got %s", got)
+	}
+}
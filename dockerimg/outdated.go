@@ -0,0 +1,192 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"ds-to-dhall/console"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const outdatedShortDescription = "reports images with newer patch, minor or major versions in their registry"
+
+var (
+	outdatedFormat string
+	outdatedAll    bool
+
+	outdatedFlagSet *flag.FlagSet
+)
+
+// outdatedImage is an image with the newest tags of each kind of update available for it.
+type outdatedImage struct {
+	Key     string `json:"key"`
+	Image   string `json:"image"`
+	Current string `json:"current"`
+	Patch   string `json:"patch,omitempty"`
+	Minor   string `json:"minor,omitempty"`
+	Major   string `json:"major,omitempty"`
+
+	Locations []Location `json:"locations,omitempty"`
+}
+
+func (o *outdatedImage) upToDate() bool {
+	return o.Patch == "" && o.Minor == "" && o.Major == ""
+}
+
+// newerVersions returns the highest tag with the same major and minor version, the highest with the same major
+// version and the highest of all, each only if it is newer than current and newer than the previous one. Prereleases
+// are ignored unless current is a prerelease itself. ok is false if current is not a semver version.
+func newerVersions(current string, tags []string) (patch, minor, major string, ok bool) {
+	cur, ok := parseSemver(current)
+	if !ok {
+		return "", "", "", false
+	}
+
+	var best [3]*semver
+	var bestTags [3]string
+	for _, tag := range tags {
+		v, ok := parseSemver(tag)
+		if !ok || v.compare(cur) <= 0 || (len(v.prerelease) > 0 && len(cur.prerelease) == 0) {
+			continue
+		}
+
+		kind := 2
+		switch {
+		case v.numbers[0] == cur.numbers[0] && v.numbers[1] == cur.numbers[1]:
+			kind = 0
+		case v.numbers[0] == cur.numbers[0]:
+			kind = 1
+		}
+		if best[kind] == nil || v.compare(*best[kind]) > 0 {
+			v := v
+			best[kind], bestTags[kind] = &v, tag
+		}
+	}
+	return bestTags[0], bestTags[1], bestTags[2], true
+}
+
+// checkOutdated looks up the newer versions of every image with a semver tag.
+func checkOutdated(ctx context.Context, c *registryClient, imgRefs []*ImageReference) ([]*outdatedImage, error) {
+	var outdated []*outdatedImage
+	for _, imgRef := range imgRefs {
+		if _, ok := parseSemver(imgRef.Version); !ok {
+			log15.Debug("skipping image without a semver tag", "image", imgRef.String())
+			continue
+		}
+
+		tags, err := c.listTags(ctx, imgRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s: %w", imgRef.Key, err)
+		}
+
+		patch, minor, major, _ := newerVersions(imgRef.Version, tags)
+		outdated = append(outdated, &outdatedImage{
+			Key:       imgRef.Key,
+			Image:     imgRef.String(),
+			Current:   imgRef.Version,
+			Patch:     patch,
+			Minor:     minor,
+			Major:     major,
+			Locations: imgRef.Locations,
+		})
+	}
+	return outdated, nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func writeOutdatedText(w io.Writer, outdated []*outdatedImage) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tCURRENT\tPATCH\tMINOR\tMAJOR")
+	for _, o := range outdated {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.Key, o.Current, orNone(o.Patch), orNone(o.Minor), orNone(o.Major))
+	}
+	return tw.Flush()
+}
+
+func outdatedFlags() *flag.FlagSet {
+	outdatedFlagSet = flag.NewFlagSet("dockerimg outdated", flag.ExitOnError)
+
+	outdatedFlagSet.StringVar(&outdatedFormat, "format", "text", "output format: text or json")
+	outdatedFlagSet.BoolVar(&outdatedAll, "all", false, "also list the images that are up to date")
+	outdatedFlagSet.StringVar(&credentialsFile, "credentials", "",
+		"registry credentials in the format of the docker config.json file")
+	outdatedFlagSet.StringArrayVar(&insecureRegistry, "insecure-registry", nil,
+		"access this registry over plain http. localhost registries always are")
+	outdatedFlagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	outdatedFlagSet.Usage = func() {
+		w := console.UsageOutput(outdatedFlagSet)
+		fmt.Fprintf(w, "dockerimg outdated %s\n", outdatedShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall dockerimg outdated: <path>\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		outdatedFlagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+	}
+
+	return outdatedFlagSet
+}
+
+func outdatedMain(args []string, ctx context.Context) {
+	_ = outdatedFlags().Parse(args)
+
+	if printHelp {
+		outdatedFlagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(outdatedFlagSet.Args()) == 0 || (outdatedFormat != "text" && outdatedFormat != "json") {
+		outdatedFlagSet.Usage()
+		os.Exit(1)
+	}
+
+	c := newCollector()
+	err := processInputs(outdatedFlagSet.Args(), c)
+	if err != nil {
+		logFatal("failed to process", "err", err)
+	}
+
+	client, err := registryClientFromFlags()
+	if err != nil {
+		logFatal("failed to load credentials", "err", err)
+	}
+
+	outdated, err := checkOutdated(ctx, client, c.variantImages())
+	if err != nil {
+		logFatal("failed to check for newer versions", "err", err)
+	}
+
+	if !outdatedAll {
+		filtered := outdated[:0]
+		for _, o := range outdated {
+			if !o.upToDate() {
+				filtered = append(filtered, o)
+			}
+		}
+		outdated = filtered
+	}
+
+	var out bytes.Buffer
+	if outdatedFormat == "json" {
+		encoder := json.NewEncoder(&out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(outdated)
+	} else {
+		err = writeOutdatedText(&out, outdated)
+	}
+	if err != nil {
+		logFatal("failed to render outdated images", "err", err)
+	}
+	_, _ = os.Stdout.Write(out.Bytes())
+}
@@ -0,0 +1,72 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewerVersions(t *testing.T) {
+	tags := []string{"3.19.0", "3.20.0", "3.20.1", "3.20.2", "3.21.0", "3.22.0-rc.1", "4.0.0", "v4.1.0", "latest", "insiders"}
+
+	tests := []struct {
+		current             string
+		patch, minor, major string
+		ok                  bool
+	}{
+		{"3.20.0", "3.20.2", "3.21.0", "v4.1.0", true},
+		{"3.21.0", "", "", "v4.1.0", true},
+		{"v4.1.0", "", "", "", true},
+		{"3.22.0-rc.0", "3.22.0-rc.1", "", "v4.1.0", true},
+		{"insiders", "", "", "", false},
+	}
+	for _, test := range tests {
+		patch, minor, major, ok := newerVersions(test.current, tags)
+		if patch != test.patch || minor != test.minor || major != test.major || ok != test.ok {
+			t.Errorf("%s: expected %q %q %q %v, got %q %q %q %v", test.current, test.patch, test.minor, test.major,
+				test.ok, patch, minor, major, ok)
+		}
+	}
+}
+
+func TestCheckOutdated(t *testing.T) {
+	srv := newTestRegistry(t, map[string][]string{
+		"sourcegraph/frontend": {"3.19.0", "3.20.0", "3.20.1", "3.21.0"},
+		"sourcegraph/redis":    {"5.0.0"},
+	})
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	credentials, err := loadCredentials(writeTestCredentials(t, host))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var imgRefs []*ImageReference
+	for _, s := range []string{"/sourcegraph/frontend:3.20.0", "/sourcegraph/redis:5.0.0", "/sourcegraph/redis:insiders"} {
+		imgRef, err := ParseImageReference(host + s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgRefs = append(imgRefs, imgRef)
+	}
+
+	outdated, err := checkOutdated(context.Background(), newRegistryClient(credentials, nil), imgRefs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = writeOutdatedText(&b, outdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `IMAGE                 CURRENT  PATCH   MINOR   MAJOR
+sourcegraph/frontend  3.20.0   3.20.1  3.21.0  -
+sourcegraph/redis     5.0.0    -       -       -
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
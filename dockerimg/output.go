@@ -0,0 +1,140 @@
+package dockerimg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+type outputFormat string
+
+const (
+	// outputDhall is the images record of imageRecordTemplate
+	outputDhall outputFormat = "dhall"
+	// outputDhallTyped is the images record annotated with an Image type
+	outputDhallTyped outputFormat = "dhall-typed"
+	outputJSON       outputFormat = "json"
+	outputYAML       outputFormat = "yaml"
+	outputCSV        outputFormat = "csv"
+	// outputLines is a registry/name:tag@sha256:digest line per image
+	outputLines outputFormat = "lines"
+)
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch f := outputFormat(s); f {
+	case outputDhall, outputDhallTyped, outputJSON, outputYAML, outputCSV, outputLines:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q", s)
+}
+
+const typedImageRecordTemplate = `let Image =
+      { registry : Optional Text, name : Text, tag : Optional Text, digest : Optional Text }
+
+let images
+    : { {{range $index, $imgRef := .}}{{if gt $index 0}}, {{end}}{{$imgRef.FormatKey}} : Image{{end}} }
+    = { {{range $index, $imgRef := .}}{{if gt $index 0}}, {{end}}{{range $imgRef.Locations}}-- {{.}}
+        {{end}}{{$imgRef.FormatKey}} =
+        { registry = {{$imgRef.FormatRegistry}}
+        , name = "{{$imgRef.Name}}"
+        , tag = {{$imgRef.FormatTag}}
+        , digest = {{$imgRef.FormatDigest}}
+        }
+      {{end}}}
+
+in  images
+`
+
+var typedTmpl = template.Must(template.New("typedImageRecordDhall").Parse(typedImageRecordTemplate))
+
+// imageEntry is an image in the JSON and YAML outputs.
+type imageEntry struct {
+	Registry string `json:"registry,omitempty" yaml:"registry,omitempty"`
+	Name     string `json:"name" yaml:"name"`
+	Tag      string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Digest   string `json:"digest,omitempty" yaml:"digest,omitempty"`
+
+	Locations []Location `json:"locations,omitempty" yaml:"locations,omitempty"`
+}
+
+// sameImage reports whether both entries refer to the same image, regardless of where they were found.
+func (e imageEntry) sameImage(other imageEntry) bool {
+	return e.Registry == other.Registry && e.Name == other.Name && e.Tag == other.Tag && e.Digest == other.Digest
+}
+
+func imageEntries(imgRefs []*ImageReference) map[string]imageEntry {
+	entries := make(map[string]imageEntry, len(imgRefs))
+	for _, imgRef := range imgRefs {
+		entries[imgRef.Key] = imageEntry{
+			Registry: imgRef.Registry,
+			Name:     imgRef.Name,
+			Tag:      imgRef.Version,
+			Digest:   imgRef.Sha256,
+
+			Locations: imgRef.Locations,
+		}
+	}
+	return entries
+}
+
+// writeImages writes the images to w in the given format.
+func writeImages(w io.Writer, format outputFormat, imgRefs []*ImageReference) error {
+	switch format {
+	case outputDhallTyped:
+		if len(imgRefs) == 0 {
+			_, err := fmt.Fprintln(w, "{=}")
+			return err
+		}
+		return typedTmpl.Execute(w, imgRefs)
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(imageEntries(imgRefs))
+	case outputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		err := encoder.Encode(imageEntries(imgRefs))
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"key", "registry", "name", "tag", "digest", "locations"})
+		for _, imgRef := range imgRefs {
+			locations := make([]string, 0, len(imgRef.Locations))
+			for _, l := range imgRef.Locations {
+				locations = append(locations, l.String())
+			}
+			_ = cw.Write([]string{imgRef.Key, imgRef.Registry, imgRef.Name, imgRef.Version, imgRef.Sha256,
+				strings.Join(locations, " ")})
+		}
+		cw.Flush()
+		return cw.Error()
+	case outputLines:
+		for _, imgRef := range imgRefs {
+			_, err := fmt.Fprintln(w, imgRef.String())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return WriteImagesRecord(w, imgRefs)
+}
+
+// loadTemplate parses a user supplied Go template. It is executed with the list of images, each with the fields
+// Key, Registry, Name, Version, Sha256 and Locations.
+func loadTemplate(file string) (*template.Template, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(file).Parse(string(contents))
+}
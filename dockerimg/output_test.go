@@ -0,0 +1,83 @@
+package dockerimg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteImages(t *testing.T) {
+	var imgRefs []*ImageReference
+	for _, image := range []string{"index.docker.io/sourcegraph/frontend:3.20.0", "redis:5.0@" + testDigest} {
+		imgRef, err := ParseImageReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgRefs = append(imgRefs, imgRef)
+	}
+	imgRefs[0].Locations = []Location{{File: "base/frontend.yaml", Line: 12}, {File: "base/migrate.yaml", Line: 3}}
+	digest := testDigest[len("sha256:"):]
+
+	tests := []struct {
+		format outputFormat
+		want   string
+	}{
+		{outputLines, "index.docker.io/sourcegraph/frontend:3.20.0\nredis:5.0@" + testDigest + "\n"},
+		{outputCSV, "key,registry,name,tag,digest,locations\n" +
+			"sourcegraph/frontend,index.docker.io,sourcegraph/frontend,3.20.0,,base/frontend.yaml:12 base/migrate.yaml:3\n" +
+			"redis,,redis,5.0," + digest + ",\n"},
+		{outputJSON, `{
+  "redis": {
+    "name": "redis",
+    "tag": "5.0",
+    "digest": "` + digest + `"
+  },
+  "sourcegraph/frontend": {
+    "registry": "index.docker.io",
+    "name": "sourcegraph/frontend",
+    "tag": "3.20.0",
+    "locations": [
+      {
+        "file": "base/frontend.yaml",
+        "line": 12
+      },
+      {
+        "file": "base/migrate.yaml",
+        "line": 3
+      }
+    ]
+  }
+}
+`},
+		{outputYAML, `redis:
+  name: redis
+  tag: "5.0"
+  digest: ` + digest + `
+sourcegraph/frontend:
+  registry: index.docker.io
+  name: sourcegraph/frontend
+  tag: 3.20.0
+  locations:
+    - file: base/frontend.yaml
+      line: 12
+    - file: base/migrate.yaml
+      line: 3
+`},
+		{outputDhallTyped, "let Image =\n      { registry : Optional Text, name : Text, tag : Optional Text, digest : Optional Text }\n\n" +
+			"let images\n    : { `sourcegraph/frontend` : Image, `redis` : Image }\n" +
+			"    = { -- base/frontend.yaml:12\n        -- base/migrate.yaml:3\n        `sourcegraph/frontend` =\n" +
+			"        { registry = Some \"index.docker.io\"\n        , name = \"sourcegraph/frontend\"\n" +
+			"        , tag = Some \"3.20.0\"\n        , digest = None Text\n        }\n      , `redis` =\n" +
+			"        { registry = None Text\n        , name = \"redis\"\n        , tag = Some \"5.0\"\n" +
+			"        , digest = Some \"" + digest + "\"\n        }\n      }\n\nin  images\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		err := writeImages(&b, test.format, imgRefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.format, b.String(), test.want)
+		}
+	}
+}
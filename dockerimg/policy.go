@@ -0,0 +1,155 @@
+package dockerimg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// imagePolicy are the rules every image has to follow, read from a YAML file like
+//
+//	allowedRegistries: [index.docker.io, us.gcr.io]
+//	requireDigest: true
+//	requireTag: true
+//	bannedTags: [latest]
+//	images:
+//	- name: sourcegraph/*
+//	  tagPattern: '^\d+\.\d+\.\d+$'
+type imagePolicy struct {
+	// AllowedRegistries are the registries images may come from. images without a registry come from docker hub,
+	// which is allowed by any of docker.io, index.docker.io or registry-1.docker.io
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	// RequireDigest requires every image to be pinned by digest
+	RequireDigest bool `yaml:"requireDigest"`
+	// RequireTag rejects images that are neither tagged nor pinned by digest, which docker resolves to latest
+	RequireTag bool `yaml:"requireTag"`
+	// BannedTags are tags no image may use
+	BannedTags []string `yaml:"bannedTags"`
+	// Images are rules for the images whose name matches a glob pattern
+	Images []imageRule `yaml:"images"`
+}
+
+type imageRule struct {
+	Name       string `yaml:"name"`
+	TagPattern string `yaml:"tagPattern"`
+
+	tagPattern *regexp.Regexp
+}
+
+func loadPolicy(file string) (*imagePolicy, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var p imagePolicy
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %w", file, err)
+	}
+
+	for i := range p.Images {
+		rule := &p.Images[i]
+		if _, err := path.Match(rule.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid image name pattern %q: %w", rule.Name, err)
+		}
+		if rule.TagPattern != "" {
+			rule.tagPattern, err = regexp.Compile(rule.TagPattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag pattern for %s: %w", rule.Name, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// violation is a policy rule broken by an image at a location.
+type violation struct {
+	location Location
+	image    string
+	message  string
+}
+
+func (v violation) String() string {
+	return fmt.Sprintf("%s: %s: %s", v.location, v.image, v.message)
+}
+
+// check returns the violations of the policy by an image.
+func (p *imagePolicy) check(imgRef *ImageReference) []string {
+	var messages []string
+
+	if len(p.AllowedRegistries) > 0 {
+		host := normalizeRegistryHost(imgRef.Registry)
+		allowed := false
+		for _, r := range p.AllowedRegistries {
+			if normalizeRegistryHost(r) == host {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			messages = append(messages, fmt.Sprintf("registry %s is not allowed", host))
+		}
+	}
+
+	if p.RequireTag && imgRef.unversioned() {
+		messages = append(messages, "image has neither a tag nor a digest")
+	}
+
+	if p.RequireDigest && imgRef.Sha256 == "" {
+		messages = append(messages, "image is not pinned by digest")
+	}
+
+	for _, tag := range p.BannedTags {
+		if imgRef.Version == tag {
+			messages = append(messages, fmt.Sprintf("tag %s is banned", tag))
+		}
+	}
+
+	for _, rule := range p.Images {
+		if rule.tagPattern == nil {
+			continue
+		}
+		if imgRef.Version == "" {
+			continue
+		}
+		if ok, _ := path.Match(rule.Name, imgRef.Name); ok && !rule.tagPattern.MatchString(imgRef.Version) {
+			messages = append(messages, fmt.Sprintf("tag %s does not match %s", imgRef.Version, rule.TagPattern))
+		}
+	}
+
+	return messages
+}
+
+// checkPolicy checks every variant of the collected images against the policy and returns the violations ordered by
+// location.
+func (c *collector) checkPolicy(p *imagePolicy) []violation {
+	var violations []violation
+
+	for _, key := range c.keys {
+		for _, v := range c.variants[key] {
+			messages := p.check(v.imgRef)
+			for _, l := range v.locations {
+				for _, m := range messages {
+					violations = append(violations, violation{location: l, image: v.imgRef.String(), message: m})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		a, b := violations[i].location, violations[j].location
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return violations
+}
@@ -0,0 +1,58 @@
+package dockerimg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.yaml")
+	err := ioutil.WriteFile(policyFile, []byte(`allowedRegistries: [docker.io, us.gcr.io]
+requireDigest: true
+requireTag: true
+bannedTags: [latest]
+images:
+- name: sourcegraph/*
+  tagPattern: '^\d+\.\d+\.\d+$'
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPolicy(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const contents = `spec:
+  containers:
+  - image: index.docker.io/sourcegraph/frontend:3.20.0@sha256:` + testDigest + `
+  - image: quay.io/sourcegraph/syntect:insiders
+  - image: redis:latest
+  - image: alpine
+`
+	c := newCollector()
+	err = processReader(strings.NewReader(contents), "deploy.yaml", formatYAML, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, v := range c.checkPolicy(p) {
+		got = append(got, v.String())
+	}
+	want := []string{
+		"deploy.yaml:4: quay.io/sourcegraph/syntect:insiders: registry quay.io is not allowed",
+		"deploy.yaml:4: quay.io/sourcegraph/syntect:insiders: image is not pinned by digest",
+		`deploy.yaml:4: quay.io/sourcegraph/syntect:insiders: tag insiders does not match ^\d+\.\d+\.\d+$`,
+		"deploy.yaml:5: redis:latest: image is not pinned by digest",
+		"deploy.yaml:5: redis:latest: tag latest is banned",
+		"deploy.yaml:6: alpine: image has neither a tag nor a digest",
+		"deploy.yaml:6: alpine: image is not pinned by digest",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got violations\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,418 @@
+package dockerimg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+
+	manifestAcceptHeader = "application/vnd.docker.distribution.manifest.list.v2+json, " +
+		"application/vnd.docker.distribution.manifest.v2+json, " +
+		"application/vnd.oci.image.index.v1+json, " +
+		"application/vnd.oci.image.manifest.v1+json"
+)
+
+type credential struct {
+	username string
+	password string
+}
+
+// registryClient talks to docker registries through the v2 HTTP API.
+type registryClient struct {
+	client      *http.Client
+	credentials map[string]credential
+	// insecure registries are accessed over plain http
+	insecure map[string]bool
+
+	mu sync.Mutex
+	// tokens are the bearer tokens by host, service and scope of the challenge they answer, and tokenKeys the key
+	// of the token last used for a repository so its following requests send it right away
+	tokens    map[string]string
+	tokenKeys map[string]string
+}
+
+func newRegistryClient(credentials map[string]credential, insecure []string) *registryClient {
+	c := &registryClient{
+		client:      &http.Client{Timeout: 30 * time.Second},
+		credentials: credentials,
+		insecure:    make(map[string]bool),
+		tokens:      make(map[string]string),
+		tokenKeys:   make(map[string]string),
+	}
+	for _, host := range insecure {
+		c.insecure[host] = true
+	}
+	return c
+}
+
+// registryClientFromFlags returns a client using the credentials and insecure registries given by the --credentials
+// and --insecure-registry flags.
+func registryClientFromFlags() (*registryClient, error) {
+	credentials := make(map[string]credential)
+	if credentialsFile != "" {
+		var err error
+		credentials, err = loadCredentials(credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newRegistryClient(credentials, insecureRegistry), nil
+}
+
+// dockerConfig is the subset of the docker CLI config.json that holds registry credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// loadCredentials reads registry credentials from a file in the format of the docker CLI config.json.
+func loadCredentials(file string) (map[string]credential, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config dockerConfig
+	err = json.Unmarshal(contents, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %w", file, err)
+	}
+
+	credentials := make(map[string]credential)
+	for host, auth := range config.Auths {
+		cred := credential{username: auth.Username, password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode auth for %s: %w", host, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("auth for %s is not of the form username:password", host)
+			}
+			cred = credential{username: parts[0], password: parts[1]}
+		}
+		credentials[normalizeRegistryHost(host)] = cred
+	}
+	return credentials, nil
+}
+
+// normalizeRegistryHost strips scheme and path from a registry address and maps the docker hub aliases to the
+// registry host.
+func normalizeRegistryHost(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "", "docker.io", "index.docker.io":
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// registryRepository returns the registry host and repository name of the image.
+func registryRepository(imgRef *ImageReference) (string, string) {
+	host := normalizeRegistryHost(imgRef.Registry)
+	name := imgRef.Name
+	if host == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host, name
+}
+
+func (c *registryClient) baseURL(host string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if c.insecure[host] || hostname == "localhost" || hostname == "127.0.0.1" {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// do sends a request for the repository, authenticating with the registry if it asks for it.
+func (c *registryClient) do(ctx context.Context, host string, repository string, method string, path string,
+	header http.Header) (*http.Response, error) {
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL(host)+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	token, ok := c.tokens[c.tokenKeys[host+"/"+repository]]
+	c.mu.Unlock()
+	if ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, params := parseChallenge(challenge)
+	cred, hasCred := c.credentials[host]
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		key := strings.Join([]string{host, params["service"], params["scope"]}, " ")
+		c.mu.Lock()
+		cached, ok := c.tokens[key]
+		c.mu.Unlock()
+		// a cached token for the challenge is only retried if the request did not already send it
+		if !ok || cached == token {
+			cached, err = c.fetchToken(ctx, params, cred, hasCred)
+			if err != nil {
+				return nil, fmt.Errorf("failed to authenticate with %s: %w", host, err)
+			}
+		}
+		c.mu.Lock()
+		c.tokens[key] = cached
+		c.tokenKeys[host+"/"+repository] = key
+		c.mu.Unlock()
+		req.Header.Set("Authorization", "Bearer "+cached)
+	case "basic":
+		if !hasCred {
+			return nil, fmt.Errorf("registry %s requires credentials", host)
+		}
+		req.SetBasicAuth(cred.username, cred.password)
+	default:
+		return nil, fmt.Errorf("registry %s requires unsupported authentication %q", host, challenge)
+	}
+
+	return c.client.Do(req)
+}
+
+// parseChallenge parses a WWW-Authenticate header like Bearer realm="...",service="...",scope="...".
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string, cred credential,
+	hasCred bool) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("bearer challenge without realm")
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	if scope, ok := params["scope"]; ok {
+		q.Set("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.username, cred.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %s", realm, resp.Status)
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", err
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// resolveDigest returns the sha256 digest (without the sha256: prefix) of the manifest the image's tag, or latest if
+// it has none, points to.
+func (c *registryClient) resolveDigest(ctx context.Context, imgRef *ImageReference) (string, error) {
+	host, name := registryRepository(imgRef)
+	tag := imgRef.Version
+	if tag == "" {
+		tag = defaultTag
+	}
+	path := fmt.Sprintf("/v2/%s/manifests/%s", name, tag)
+	header := http.Header{"Accept": []string{manifestAcceptHeader}}
+
+	resp, err := c.do(ctx, host, name, http.MethodHead, path, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if d := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(d, "sha256:") {
+			return strings.TrimPrefix(d, "sha256:"), nil
+		}
+	}
+
+	// not every registry answers HEAD requests or sends the digest header, fall back to hashing the manifest
+	resp, err = c.do(ctx, host, name, http.MethodGet, path, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get manifest of %s:%s from %s: %s", name, tag, host, resp.Status)
+	}
+	if d := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(d, "sha256:") {
+		return strings.TrimPrefix(d, "sha256:"), nil
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// nextLink returns the path and query of the next page from a Link header like </v2/name/tags/list?n=2&last=b>;
+// rel="next".
+func nextLink(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// listTags returns all tags of the image's repository through the v2 tags API, following pagination links.
+func (c *registryClient) listTags(ctx context.Context, imgRef *ImageReference) ([]string, error) {
+	host, name := registryRepository(imgRef)
+
+	var tags []string
+	path := fmt.Sprintf("/v2/%s/tags/list?n=1000", name)
+	for path != "" {
+		resp, err := c.do(ctx, host, name, http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		} else {
+			err = fmt.Errorf("failed to list tags of %s from %s: %s", name, host, resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, page.Tags...)
+		path = nextLink(resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// resolveDigests fills in the digest of every image that does not have one yet.
+func resolveDigests(ctx context.Context, c *registryClient, imgRefs []*ImageReference) error {
+	for _, imgRef := range imgRefs {
+		if imgRef.Sha256 != "" {
+			continue
+		}
+
+		d, err := c.resolveDigest(ctx, imgRef)
+		if err != nil {
+			return fmt.Errorf("failed to resolve digest of %s: %w", imgRef.Key, err)
+		}
+		imgRef.Sha256 = d
+	}
+	return nil
+}
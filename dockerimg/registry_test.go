@@ -0,0 +1,189 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testDigest = "sha256:4c1e997385b8fb4ad4d1d3c7e5124e4d6ef6a1d4c3bd0e1d0a3a1a0a1b6b2f3e"
+
+// newTestRegistry starts a registry stand-in that serves manifests and tags behind bearer token authentication.
+func newTestRegistry(t *testing.T, tags map[string][]string) *httptest.Server {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token": "token-for-%s"}`, r.URL.Query().Get("scope"))
+	})
+
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+		if len(parts) < 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		name := strings.Join(parts[:len(parts)-2], "/")
+		scope := fmt.Sprintf("repository:%s:pull", name)
+
+		if r.Header.Get("Authorization") != "Bearer token-for-"+scope {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="%s"`, srv.URL, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch parts[len(parts)-2] {
+		case "manifests":
+			for _, tag := range tags[name] {
+				if tag == parts[len(parts)-1] {
+					w.Header().Set("Docker-Content-Digest", testDigest)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case "tags":
+			fmt.Fprintf(w, `{"name": %q, "tags": [%s]}`, name, `"`+strings.Join(tags[name], `","`)+`"`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func writeTestCredentials(t *testing.T, host string) string {
+	dir, err := ioutil.TempDir("", "dockerimg-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	file := filepath.Join(dir, "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("ci:secret"))
+	err = ioutil.WriteFile(file, []byte(fmt.Sprintf(`{"auths": {"http://%s/v1/": {"auth": %q}}}`, host, auth)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestResolveDigests(t *testing.T) {
+	srv := newTestRegistry(t, map[string][]string{"sourcegraph/frontend": {"3.20.0"}})
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	credentials, err := loadCredentials(writeTestCredentials(t, host))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frontend, err := ParseImageReference(host + "/sourcegraph/frontend:3.20.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := ParseImageReference(host + "/sourcegraph/frontend:3.19.0@" + testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = resolveDigests(context.Background(), newRegistryClient(credentials, nil), []*ImageReference{frontend, pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if "sha256:"+frontend.Sha256 != testDigest {
+		t.Errorf("expected digest %s, got %s", testDigest, frontend.Sha256)
+	}
+
+	missing, err := ParseImageReference(host + "/sourcegraph/frontend:0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resolveDigests(context.Background(), newRegistryClient(credentials, nil), []*ImageReference{missing})
+	if err == nil {
+		t.Errorf("expected an error for a missing tag")
+	}
+
+	frontend, err = ParseImageReference(host + "/sourcegraph/frontend:3.20.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = resolveDigests(context.Background(), newRegistryClient(nil, nil), []*ImageReference{frontend})
+	if err == nil {
+		t.Errorf("expected an error without credentials")
+	}
+}
+
+func TestRegistryTokenReuse(t *testing.T) {
+	var srv *httptest.Server
+	var tokenRequests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		fmt.Fprintf(w, `{"token": "token-for-%s"}`, r.URL.Query().Get("scope"))
+	})
+	mux.HandleFunc("/v2/sourcegraph/frontend/", func(w http.ResponseWriter, r *http.Request) {
+		scope := "repository:sourcegraph/frontend:pull"
+		if r.Header.Get("Authorization") != "Bearer token-for-"+scope {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="%s"`, srv.URL, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/3.20.0"):
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/sourcegraph/frontend/tags/list?n=1&last=3.19.0>; rel="next"`)
+			fmt.Fprint(w, `{"tags": ["3.19.0"]}`)
+		default:
+			fmt.Fprint(w, `{"tags": ["3.20.0"]}`)
+		}
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	imgRef, err := ParseImageReference(strings.TrimPrefix(srv.URL, "http://") + "/sourcegraph/frontend:3.20.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newRegistryClient(nil, nil)
+	tags, err := c.listTags(context.Background(), imgRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, " ") != "3.19.0 3.20.0" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	_, err = c.resolveDigest(context.Background(), imgRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("expected a single token for the repository, got %d", tokenRequests)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.docker.io/token" ||
+		params["service"] != "registry.docker.io" || params["scope"] != "repository:library/alpine:pull" {
+		t.Errorf("unexpected challenge parse result %s %v", scheme, params)
+	}
+}
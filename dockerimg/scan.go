@@ -0,0 +1,360 @@
+package dockerimg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+type inputFormat string
+
+const (
+	formatAuto  inputFormat = "auto"
+	formatYAML  inputFormat = "yaml"
+	formatDhall inputFormat = "dhall"
+	formatLines inputFormat = "lines"
+)
+
+func parseInputFormat(s string) (inputFormat, error) {
+	switch f := inputFormat(s); f {
+	case formatAuto, formatYAML, formatDhall, formatLines:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown input format %q", s)
+}
+
+// imageHit is a candidate image reference found in an input.
+type imageHit struct {
+	// value is the reference as written in the input, without quotes
+	value string
+	// line is the 1-based line of the reference
+	line int
+	// column is the 1-based byte column the reference starts at, 0 if it is not known
+	column int
+	// context describes where the reference was found, the YAML path or the kind of Dhall literal
+	context string
+	// strong hits come from fields that hold images, like a container's image. weak hits (args, env values and
+	// other text literals) are only accepted if they clearly look like an image reference
+	strong bool
+}
+
+// accepted reports whether the hit should be parsed as an image reference at all.
+func (h imageHit) accepted() bool {
+	if h.value == "" || strings.ContainsAny(h.value, " \t\n") {
+		return false
+	}
+	if h.strong {
+		return true
+	}
+
+	// weak hits must name a repository with a path and a tag or digest, like sourcegraph/frontend:3.20
+	name := h.value
+	if i := strings.IndexAny(name, "@"); i >= 0 {
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		name = name[:i]
+	} else {
+		return false
+	}
+	return strings.Contains(name, "/")
+}
+
+// scan returns the image reference candidates found in contents. In auto mode contents are scanned as YAML unless
+// their root is a flow collection, like a Dhall record, or they cannot be parsed as YAML, and as Dhall otherwise.
+// Structured scanning falls back to the line based heuristic if the contents cannot be parsed in the given format.
+func scan(contents []byte, format inputFormat) []imageHit {
+	switch format {
+	case formatYAML:
+		hits, err := scanYAML(contents, false)
+		if err == nil {
+			return hits
+		}
+	case formatDhall:
+		return scanDhall(contents)
+	case formatAuto:
+		hits, err := scanYAML(contents, true)
+		if err == nil {
+			return hits
+		}
+		// inputs without any text literal are neither Dhall nor valid YAML, like a YAML template
+		hits = scanDhall(contents)
+		if len(hits) > 0 || err == errNotYAML {
+			return hits
+		}
+	}
+	return scanLines(contents)
+}
+
+var errNotYAML = fmt.Errorf("input is not a YAML mapping or sequence")
+
+// scanYAML returns the hits of the image fields of a YAML file. With blockOnly, a flow collection root is not YAML,
+// since a Dhall record like { a = "b"\n, c = 1\n} parses as a YAML flow mapping.
+func scanYAML(contents []byte, blockOnly bool) ([]imageHit, error) {
+	var hits []imageHit
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		root := doc.Content[0]
+		if (root.Kind != yaml.MappingNode && root.Kind != yaml.SequenceNode) || (blockOnly && root.Style&yaml.FlowStyle != 0) {
+			return nil, errNotYAML
+		}
+		hits = walkYAML(root, "", "", hits)
+	}
+
+	// yaml columns count characters, turn them into byte columns of the unquoted value
+	lines := strings.Split(string(contents), "\n")
+	for i := range hits {
+		h := &hits[i]
+		if h.line > len(lines) {
+			h.column = 0
+			continue
+		}
+		h.column = byteColumn(lines[h.line-1], h.column, h.value)
+	}
+
+	return hits, nil
+}
+
+// imageArgKeys are fields whose values may contain images besides the image field itself.
+var imageArgKeys = map[string]bool{
+	"args":    true,
+	"command": true,
+	"value":   true,
+}
+
+func walkYAML(node *yaml.Node, path string, key string, hits []imageHit) []imageHit {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			hits = walkYAML(node.Content[i+1], joinYAMLPath(path, k), k, hits)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			hits = walkYAML(item, fmt.Sprintf("%s[%d]", path, i), key, hits)
+		}
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" {
+			break
+		}
+		if key == "image" || imageArgKeys[key] {
+			hits = append(hits, imageHit{value: node.Value, line: node.Line, column: node.Column, context: path,
+				strong: key == "image"})
+		}
+	case yaml.AliasNode:
+		// aliased values are reported where the anchor is defined
+	}
+	return hits
+}
+
+// byteColumn returns the byte column of value in line, given the character column of the (possibly quoted) value
+// starts at. It returns 0 if the value is not found there.
+func byteColumn(line string, column int, value string) int {
+	offset := 0
+	for n := 1; n < column && offset < len(line); n++ {
+		_, size := utf8.DecodeRuneInString(line[offset:])
+		offset += size
+	}
+	if offset < len(line) && (line[offset] == '"' || line[offset] == '\'') {
+		offset++
+	}
+	if !strings.HasPrefix(line[offset:], value) {
+		return 0
+	}
+	return offset + 1
+}
+
+func joinYAMLPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var dhallImageField = regexp.MustCompile(`(^|[^A-Za-z0-9_/-])image\s*=\s*(Some\s+)?$`)
+
+// scanDhall returns the double quoted text literals of a Dhall file. Literals assigned to an image field are strong
+// hits. Comments and multi-line literals are skipped.
+func scanDhall(contents []byte) []imageHit {
+	var hits []imageHit
+
+	s := string(contents)
+	line := 1
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\n':
+			line++
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return hits
+			}
+			i += end - 1
+		case strings.HasPrefix(s[i:], "{-"):
+			depth := 0
+			for ; i < len(s); i++ {
+				if s[i] == '\n' {
+					line++
+				} else if strings.HasPrefix(s[i:], "{-") {
+					depth++
+					i++
+				} else if strings.HasPrefix(s[i:], "-}") {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		case strings.HasPrefix(s[i:], "''"):
+			end, _ := endOfDhallMultilineText(s, i)
+			if end < 0 {
+				return hits
+			}
+			line += strings.Count(s[i:end], "\n")
+			i = end + 1
+		case s[i] == '"':
+			start, startLine := i, line
+			end, interpolated := endOfDhallText(s, start)
+			if end < 0 {
+				return hits
+			}
+			line += strings.Count(s[start:end], "\n")
+			i = end
+			// the value of an interpolated literal is only known when it is evaluated
+			if interpolated {
+				continue
+			}
+
+			value, err := strconv.Unquote(s[start : end+1])
+			if err != nil {
+				value = s[start+1 : end]
+			}
+			lineStart := strings.LastIndexByte(s[:start], '\n') + 1
+			strong := dhallImageField.MatchString(s[lineStart:start])
+			hits = append(hits, imageHit{value: value, line: startLine, column: start - lineStart + 2,
+				context: "text literal", strong: strong})
+		}
+	}
+
+	return hits
+}
+
+// endOfDhallText returns the index of the quote ending the double quoted text literal that starts at s[start], and
+// whether the literal interpolates an expression with ${ }. It returns -1 if the literal is not terminated.
+func endOfDhallText(s string, start int) (int, bool) {
+	interpolated := false
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			return i, interpolated
+		case strings.HasPrefix(s[i:], "${"):
+			interpolated = true
+			i = endOfDhallInterpolation(s, i)
+			if i < 0 {
+				return -1, interpolated
+			}
+		}
+	}
+	return -1, interpolated
+}
+
+// endOfDhallMultilineText returns the index of the first of the two single quotes ending the multi-line text literal
+// that starts at s[start], and whether the literal interpolates an expression. Within the literal three single quotes
+// escape two, and two single quotes before ${ escape the interpolation. It returns -1 if the literal is not terminated.
+func endOfDhallMultilineText(s string, start int) (int, bool) {
+	interpolated := false
+	for i := start + 2; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "'''"):
+			i += 2
+		case strings.HasPrefix(s[i:], "''${"):
+			i += 3
+		case strings.HasPrefix(s[i:], "''"):
+			return i, interpolated
+		case strings.HasPrefix(s[i:], "${"):
+			interpolated = true
+			i = endOfDhallInterpolation(s, i)
+			if i < 0 {
+				return -1, interpolated
+			}
+		}
+	}
+	return -1, interpolated
+}
+
+// endOfDhallInterpolation returns the index of the brace closing the ${ } interpolation that starts at s[start], or
+// -1 if it is not closed. The expression can contain records and text literals with braces of their own.
+func endOfDhallInterpolation(s string, start int) int {
+	depth := 0
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case s[i] == '"':
+			end, _ := endOfDhallText(s, i)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case strings.HasPrefix(s[i:], "''"):
+			end, _ := endOfDhallMultilineText(s, i)
+			if end < 0 {
+				return -1
+			}
+			i = end + 1
+		}
+	}
+	return -1
+}
+
+// lineContext is the context of hits found by the line based heuristic.
+const lineContext = "line"
+
+// scanLines is the line based heuristic that tries to parse what is left of every line after stripping list dashes
+// and an image: prefix.
+func scanLines(contents []byte) []imageHit {
+	var hits []imageHit
+
+	for i, raw := range strings.Split(string(contents), "\n") {
+		line := raw
+		for _, p := range []string{"-", "image:"} {
+			line = strings.TrimSpace(line)
+			line = strings.TrimPrefix(line, p)
+			line = strings.TrimSpace(line)
+		}
+
+		if line == "" {
+			continue
+		}
+		hits = append(hits, imageHit{value: line, line: i + 1, column: strings.Index(raw, line) + 1,
+			context: lineContext, strong: true})
+	}
+
+	return hits
+}
@@ -0,0 +1,189 @@
+package dockerimg
+
+import (
+	"strings"
+	"testing"
+)
+
+func scannedImages(t *testing.T, contents string, format inputFormat) []string {
+	t.Helper()
+
+	c := newCollector()
+	err := processReader(strings.NewReader(contents), "test", format, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgRefs, err := c.imageReferences(conflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, imgRef := range imgRefs {
+		keys = append(keys, imgRef.Key+":"+imgRef.Version)
+	}
+	return keys
+}
+
+func TestScanYAML(t *testing.T) {
+	const contents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+spec:
+  template:
+    spec:
+      containers:
+      - name: frontend
+        image: "index.docker.io/sourcegraph/frontend:3.20.0"
+        args: ["--migrator-image", "sourcegraph/migrator:3.20.0", "serve:all"]
+        env:
+        - name: ADDR
+          value: "localhost:3000"
+---
+kind: Pod
+spec:
+  initContainers:
+  - image: 'alpine:3.12'
+`
+
+	got := scannedImages(t, contents, formatYAML)
+	want := []string{"sourcegraph/frontend:3.20.0", "sourcegraph/migrator:3.20.0", "alpine:3.12"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	hits := scan([]byte(contents), formatYAML)
+	if hits[0].line != 10 || hits[0].column != 17 || hits[0].context != "spec.template.spec.containers[0].image" {
+		t.Errorf("unexpected location of first hit: %+v", hits[0])
+	}
+}
+
+func TestScanDhall(t *testing.T) {
+	const contents = `-- "sourcegraph/commented:1.0"
+{- "sourcegraph/block:1.0" {- "nested:1.0" -} -}
+{ containers =
+  [ { name = "frontend", image = "index.docker.io/sourcegraph/frontend:3.20.0" }
+  , { name = "redis", image = Some "redis:5.0" }
+  ]
+, description = ''
+    "sourcegraph/multiline:1.0"
+    ''
+, args = [ "sourcegraph/migrator:3.20.0", "key:value" ]
+}
+`
+
+	got := scannedImages(t, contents, formatDhall)
+	want := []string{"sourcegraph/frontend:3.20.0", "redis:5.0", "sourcegraph/migrator:3.20.0"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, hit := range scan([]byte(contents), formatDhall) {
+		if hit.value == "sourcegraph/migrator:3.20.0" && hit.line != 10 {
+			t.Errorf("got line %d for migrator, want 10", hit.line)
+		}
+	}
+}
+
+func TestScanDhallLiterals(t *testing.T) {
+	const contents = `{ a = "sourcegraph/${"x"}frontend:3.20.0"
+, b = "${ { c = "}" }.c }sourcegraph/gitserver:3.20.0"
+, c = "first
+second"
+, image = "sourcegraph/searcher:3.20.0"
+}
+`
+
+	hits := scan([]byte(contents), formatDhall)
+	if len(hits) != 2 {
+		t.Fatalf("expected the interpolated literals to be skipped, got %+v", hits)
+	}
+	if hits[0].value != "first\nsecond" || hits[0].line != 3 || hits[0].column != 8 {
+		t.Errorf("expected the multi-line literal at its start, got %+v", hits[0])
+	}
+	if hits[1].value != "sourcegraph/searcher:3.20.0" || hits[1].line != 5 || !hits[1].strong {
+		t.Errorf("unexpected hit after the multi-line literal: %+v", hits[1])
+	}
+}
+
+func TestScanDhallMultilineLiterals(t *testing.T) {
+	// neither the escaped quotes nor the escaped interpolation end the literal
+	const contents = `{ script = ''
+    echo '''quoted'''
+    echo ''${HOME}
+    echo ${ "''" }
+    ''
+, image = "sourcegraph/searcher:3.20.0"
+}
+`
+
+	hits := scan([]byte(contents), formatDhall)
+	if len(hits) != 1 || hits[0].value != "sourcegraph/searcher:3.20.0" || hits[0].line != 6 || !hits[0].strong {
+		t.Errorf("expected only the image after the multi-line literal, got %+v", hits)
+	}
+}
+
+func TestScanAutoDhall(t *testing.T) {
+	// a multi-line Dhall record is a valid YAML flow mapping
+	const contents = "{ frontend = \"sourcegraph/frontend:3.20.0\"\n, b = 1\n}\n"
+
+	got := scannedImages(t, contents, formatAuto)
+	if strings.Join(got, " ") != "sourcegraph/frontend:3.20.0" {
+		t.Errorf("got %v", got)
+	}
+
+	hits := scan([]byte("{ image = \"redis:5.0\" }"), formatAuto)
+	if len(hits) != 1 || hits[0].context != "text literal" || !hits[0].strong {
+		t.Errorf("expected a single line Dhall record to be scanned as Dhall, got %+v", hits)
+	}
+}
+
+func TestScanAutoFallback(t *testing.T) {
+	// not valid YAML, the line heuristic still finds the image
+	const contents = "image: sourcegraph/frontend:3.20.0\n\t{{ template }}: [\n"
+
+	got := scannedImages(t, contents, formatAuto)
+	if strings.Join(got, " ") != "sourcegraph/frontend:3.20.0" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestScanUnversionedReferences(t *testing.T) {
+	contents := `containers:
+- image: sourcegraph/frontend@` + testDigest + `
+- image: redis
+- image: docker.io/library/redis
+  args: ["sourcegraph/migrator", "sourcegraph/alpine@` + testDigest + `"]
+`
+
+	c := newCollector()
+	err := processReader(strings.NewReader(contents), "test", formatYAML, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgRefs, err := c.imageReferences(conflictDistinct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "sourcegraph/frontend=sourcegraph/frontend@" + testDigest + " redis=redis " +
+		"library/redis=docker.io/library/redis sourcegraph/alpine=sourcegraph/alpine@" + testDigest
+	if got := formatReferences(imgRefs); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	c = newCollector()
+	c.normalize = true
+	err = processReader(strings.NewReader(contents), "test", formatYAML, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgRefs, err = c.imageReferences(conflictFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "sourcegraph/frontend=sourcegraph/frontend@" + testDigest + " redis=redis sourcegraph/alpine=sourcegraph/alpine@" + testDigest
+	if got := formatReferences(imgRefs); got != want {
+		t.Errorf("normalized: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package dockerimg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var semverTag = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// semver is a tag like 3.20.1, v3.20 or 3.20.1-rc.1. Missing minor and patch versions count as 0.
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+func parseSemver(tag string) (semver, bool) {
+	m := semverTag.FindStringSubmatch(tag)
+	if m == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	for i := 0; i < 3; i++ {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+		v.numbers[i] = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 if v is lower than, equal to or higher than w, following the precedence rules of
+// semantic versioning.
+func (v semver) compare(w semver) int {
+	for i := range v.numbers {
+		if c := compareInts(v.numbers[i], w.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	// a release is higher than its prereleases
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		a, b := v.prerelease[i], w.prerelease[i]
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInts(an, bn)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(a, b)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.prerelease), len(w.prerelease))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
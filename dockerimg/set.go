@@ -0,0 +1,376 @@
+package dockerimg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"ds-to-dhall/console"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const setShortDescription = "rewrites the tags and digests of images in YAML and Dhall files in place"
+
+var (
+	setImages     []string
+	setImagesFile string
+	setDryRun     bool
+
+	setFlagSet *flag.FlagSet
+)
+
+func usageSetArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) YAML and Dhall files or directories to rewrite")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+// parseTarget parses name=tag[@sha256:digest] or name=@sha256:digest, where name may include a registry.
+func parseTarget(s string) (*ImageReference, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("image %q is not of the form name=tag[@digest]", s)
+	}
+	if strings.HasPrefix(parts[1], "@") {
+		return ParseImageReference(parts[0] + parts[1])
+	}
+	return ParseImageReference(parts[0] + ":" + parts[1])
+}
+
+// loadTargets reads the images to set from a file with an image reference per line, like written by --format lines,
+// or from a JSON file written by --format json.
+func loadTargets(file string) ([]*ImageReference, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*ImageReference
+	if filepath.Ext(file) == ".json" {
+		var entries map[string]imageEntry
+		err = json.Unmarshal(contents, &entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse images file %s: %w", file, err)
+		}
+		for _, entry := range entries {
+			targets = append(targets, &ImageReference{Registry: entry.Registry, Name: entry.Name, Version: entry.Tag,
+				Sha256: entry.Digest, Key: entry.Name})
+		}
+		return targets, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		imgRef, err := ParseImageReference(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		targets = append(targets, imgRef)
+	}
+	return targets, scanner.Err()
+}
+
+// imageSetter rewrites the references to images by their name.
+type imageSetter struct {
+	targets map[string][]*ImageReference
+}
+
+func newImageSetter(targets []*ImageReference) (*imageSetter, error) {
+	s := &imageSetter{targets: make(map[string][]*ImageReference)}
+	for _, t := range targets {
+		for _, other := range s.targets[t.Key] {
+			if sameRegistry(other.Registry, t.Registry) {
+				return nil, fmt.Errorf("image %s is set more than once", t.Key)
+			}
+		}
+		s.targets[t.Key] = append(s.targets[t.Key], t)
+	}
+	return s, nil
+}
+
+func sameRegistry(a, b string) bool {
+	return a == b || (a != "" && b != "" && normalizeRegistryHost(a) == normalizeRegistryHost(b))
+}
+
+// target returns the image to set for a reference. Targets with a registry only match references with the same
+// registry, those without match references to any registry.
+func (s *imageSetter) target(imgRef *ImageReference) (*ImageReference, bool) {
+	var match *ImageReference
+	for _, t := range s.targets[imgRef.Key] {
+		if t.Registry != "" && normalizeRegistryHost(t.Registry) == normalizeRegistryHost(imgRef.Registry) {
+			return t, true
+		}
+		if t.Registry == "" {
+			match = t
+		}
+	}
+	return match, match != nil
+}
+
+// change is a line rewritten by set.
+type change struct {
+	location Location
+	oldLine  string
+	newLine  string
+}
+
+// isReferenceChar reports whether c may be part of an image reference.
+func isReferenceChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._-:/@", c) >= 0
+}
+
+// replaceReference replaces every occurrence of old in line that is not part of a longer reference.
+func replaceReference(line string, old string, new string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(line, old)
+		if i < 0 {
+			break
+		}
+		end := i + len(old)
+		if (i > 0 && isReferenceChar(line[i-1])) || (end < len(line) && isReferenceChar(line[end])) {
+			b.WriteString(line[:end])
+		} else {
+			b.WriteString(line[:i])
+			b.WriteString(new)
+		}
+		line = line[end:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// replaceHit replaces the reference of the hit in its line. If the column of the hit is not known every occurrence
+// of the reference is replaced.
+func replaceHit(line string, hit imageHit, new string) string {
+	start := hit.column - 1
+	if start >= 0 && start <= len(line) && strings.HasPrefix(line[start:], hit.value) {
+		return line[:start] + new + line[start+len(hit.value):]
+	}
+	return replaceReference(line, hit.value, new)
+}
+
+// imagesRecordEntry matches the entries of the images records written by dockerimg and ds2dhall --images, which
+// split an image into registry, name, tag and digest fields.
+var imagesRecordEntry = regexp.MustCompile(`\{\s*registry\s*=\s*(None Text|Some[ \t]+"[^"\n]*")\s*,\s*` +
+	`name\s*=\s*"([^"\n]*)"\s*,\s*tag\s*=\s*(None Text|Some[ \t]+"[^"\n]*")\s*,\s*digest\s*=\s*(None Text|Some[ \t]+"[^"\n]*")\s*\}`)
+
+// optionalText returns the value of a None Text or Some "..." literal.
+func optionalText(s string) string {
+	if !strings.HasPrefix(s, "Some") {
+		return ""
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(s, "Some")))
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// setRecords rewrites the tag and digest fields of the images record entries in contents. The rewritten fields stay
+// on their lines.
+func (s *imageSetter) setRecords(contents string) string {
+	matches := imagesRecordEntry.FindAllStringSubmatchIndex(contents, -1)
+	// rewrite back to front so the offsets of the entries before a rewritten one stay valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		imgRef := &ImageReference{Registry: optionalText(contents[m[2]:m[3]]), Name: contents[m[4]:m[5]],
+			Version: optionalText(contents[m[6]:m[7]]), Sha256: optionalText(contents[m[8]:m[9]])}
+		parsed, err := ParseImageReference(imgRef.String())
+		if err != nil {
+			continue
+		}
+		t, ok := s.target(parsed)
+		if !ok {
+			continue
+		}
+
+		updated := *imgRef
+		updated.Version = t.Version
+		updated.Sha256 = t.Sha256
+		contents = contents[:m[6]] + updated.FormatTag() + contents[m[7]:m[8]] + updated.FormatDigest() +
+			contents[m[9]:]
+	}
+	return contents
+}
+
+// set returns the contents with the images rewritten and the lines that changed.
+func (s *imageSetter) set(source string, contents []byte, format inputFormat) ([]byte, []change) {
+	original := strings.Split(string(contents), "\n")
+	lines := strings.Split(s.setRecords(string(contents)), "\n")
+
+	// rewrite right to left so the columns of the hits before a rewritten reference stay valid
+	hits := scan(contents, format)
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].line != hits[j].line {
+			return hits[i].line < hits[j].line
+		}
+		return hits[i].column > hits[j].column
+	})
+
+	for _, hit := range hits {
+		if hit.line < 1 || hit.line > len(lines) {
+			continue
+		}
+		imgRef, ok := hit.reference()
+		if !ok {
+			continue
+		}
+		t, ok := s.target(imgRef)
+		if !ok {
+			continue
+		}
+
+		updated := *imgRef
+		updated.Version = t.Version
+		updated.Sha256 = t.Sha256
+		if updated.String() == hit.value {
+			continue
+		}
+
+		i := hit.line - 1
+		lines[i] = replaceHit(lines[i], hit, updated.String())
+	}
+
+	var changes []change
+	for i := range lines {
+		if original[i] != lines[i] {
+			changes = append(changes, change{location: Location{File: source, Line: i + 1}, oldLine: original[i],
+				newLine: lines[i]})
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), changes
+}
+
+func (s *imageSetter) setFile(path string, dryRun bool) ([]change, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, changes := s.set(path, contents, fileFormat(path))
+	if len(changes) == 0 || dryRun {
+		return changes, nil
+	}
+	return changes, ioutil.WriteFile(path, updated, info.Mode().Perm())
+}
+
+// writeDiff writes the changes of a file as a unified diff with one hunk per changed line.
+func writeDiff(b *bytes.Buffer, changes []change) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "--- %s\n+++ %s\n", changes[0].location.File, changes[0].location.File)
+	for _, c := range changes {
+		fmt.Fprintf(b, "@@ -%d +%d @@\n-%s\n+%s\n", c.location.Line, c.location.Line, c.oldLine, c.newLine)
+	}
+}
+
+func setFlags() *flag.FlagSet {
+	setFlagSet = flag.NewFlagSet("dockerimg set", flag.ExitOnError)
+
+	setFlagSet.StringArrayVarP(&setImages, "image", "i", nil,
+		"image to set as name=tag[@sha256:digest]. the name may include a registry to only rewrite images from that registry")
+	setFlagSet.StringVar(&setImagesFile, "images", "",
+		"file with the images to set, with a reference per line (--format lines) or JSON (--format json)")
+	setFlagSet.BoolVar(&setDryRun, "dry-run", false, "print the changes as a diff instead of rewriting the files")
+	setFlagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	setFlagSet.Usage = func() {
+		w := console.UsageOutput(setFlagSet)
+		fmt.Fprintf(w, "dockerimg set %s\n", setShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall dockerimg set: --image <name>=<tag> <path>\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		setFlagSet.PrintDefaults()
+		fmt.Fprintln(w, usageSetArgs())
+	}
+
+	return setFlagSet
+}
+
+func setMain(args []string, ctx context.Context) {
+	_ = setFlags().Parse(args)
+
+	if printHelp {
+		setFlagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(setFlagSet.Args()) == 0 || (len(setImages) == 0 && setImagesFile == "") {
+		setFlagSet.Usage()
+		os.Exit(1)
+	}
+
+	var targets []*ImageReference
+	for _, s := range setImages {
+		t, err := parseTarget(s)
+		if err != nil {
+			logFatal("invalid --image", "err", err)
+		}
+		targets = append(targets, t)
+	}
+	if setImagesFile != "" {
+		ts, err := loadTargets(setImagesFile)
+		if err != nil {
+			logFatal("failed to load images file", "err", err)
+		}
+		targets = append(targets, ts...)
+	}
+
+	setter, err := newImageSetter(targets)
+	if err != nil {
+		logFatal("invalid images", "err", err)
+	}
+
+	var diff bytes.Buffer
+	numChanges := 0
+	err = walkInputs(setFlagSet.Args(), func(path string) error {
+		changes, err := setter.setFile(path, setDryRun)
+		if err != nil {
+			return fmt.Errorf("error on file %s: %w", path, err)
+		}
+		numChanges += len(changes)
+
+		if setDryRun {
+			writeDiff(&diff, changes)
+			return nil
+		}
+		for _, c := range changes {
+			fmt.Printf("%s: %s\n", c.location, strings.TrimSpace(c.newLine))
+		}
+		return nil
+	})
+	if err != nil {
+		logFatal("failed to set images", "err", err)
+	}
+
+	if setDryRun {
+		_, _ = os.Stdout.Write(diff.Bytes())
+	}
+	if numChanges == 0 {
+		log15.Info("no images changed")
+	}
+}
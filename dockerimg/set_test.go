@@ -0,0 +1,108 @@
+package dockerimg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSetImages(t *testing.T) {
+	var targets []*ImageReference
+	for _, s := range []string{"sourcegraph/frontend=3.21.0", "docker.io/redis=6.0@" + testDigest} {
+		target, err := parseTarget(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+	setter, err := newImageSetter(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const yamlContents = `spec:
+  containers:
+  - image: "index.docker.io/sourcegraph/frontend:3.20.0@sha256:` + "abc" + `" # pinned
+    args: ["--sidecar=sourcegraph/frontend:3.20.0", sourcegraph/frontend:3.20.0]
+  - image: redis:5.0
+  - image: us.gcr.io/redis:5.0
+  - image: sourcegraph/frontend-internal:3.20.0
+`
+	updated, changes := setter.set("deploy.yaml", []byte(yamlContents), formatYAML)
+	want := `spec:
+  containers:
+  - image: "index.docker.io/sourcegraph/frontend:3.20.0@sha256:abc" # pinned
+    args: ["--sidecar=sourcegraph/frontend:3.20.0", sourcegraph/frontend:3.21.0]
+  - image: redis:6.0@` + testDigest + `
+  - image: us.gcr.io/redis:5.0
+  - image: sourcegraph/frontend-internal:3.20.0
+`
+	if string(updated) != want {
+		t.Errorf("got\n%s\nwant\n%s", updated, want)
+	}
+
+	var b bytes.Buffer
+	writeDiff(&b, changes)
+	wantDiff := `--- deploy.yaml
++++ deploy.yaml
+@@ -4 +4 @@
+-    args: ["--sidecar=sourcegraph/frontend:3.20.0", sourcegraph/frontend:3.20.0]
++    args: ["--sidecar=sourcegraph/frontend:3.20.0", sourcegraph/frontend:3.21.0]
+@@ -5 +5 @@
+-  - image: redis:5.0
++  - image: redis:6.0@` + testDigest + `
+`
+	if b.String() != wantDiff {
+		t.Errorf("got diff\n%s\nwant\n%s", b.String(), wantDiff)
+	}
+
+	const dhallContents = `{ image = Some "index.docker.io/sourcegraph/frontend:3.20.0", name = "frontend" }`
+	updated, _ = setter.set("frontend.dhall", []byte(dhallContents), formatDhall)
+	if !strings.Contains(string(updated), `"index.docker.io/sourcegraph/frontend:3.21.0"`) {
+		t.Errorf("got %s", updated)
+	}
+}
+
+func TestSetImagesRecord(t *testing.T) {
+	target, err := parseTarget("sourcegraph/frontend=3.21.0@" + testDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setter, err := newImageSetter([]*ImageReference{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var imgRefs []*ImageReference
+	for _, image := range []string{"index.docker.io/sourcegraph/frontend:3.20.0", "redis:5.0"} {
+		imgRef, err := ParseImageReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgRefs = append(imgRefs, imgRef)
+	}
+
+	// the records written by dockerimg split the images into fields
+	for _, format := range []outputFormat{outputDhall, outputDhallTyped} {
+		var b bytes.Buffer
+		err = writeImages(&b, format, imgRefs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		updated, changes := setter.set("images.dhall", b.Bytes(), formatDhall)
+		if len(changes) != 2 {
+			t.Errorf("%s: expected the tag and digest lines to change, got %+v", format, changes)
+		}
+		for _, want := range []string{
+			`registry = Some "index.docker.io"`,
+			`tag = Some "3.21.0"`,
+			`digest = Some "` + strings.TrimPrefix(testDigest, "sha256:") + `"`,
+			`tag = Some "5.0"`,
+		} {
+			if !strings.Contains(string(updated), want) {
+				t.Errorf("%s: expected %s in\n%s", format, want, updated)
+			}
+		}
+	}
+}
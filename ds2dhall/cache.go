@@ -0,0 +1,286 @@
+package ds2dhall
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/console"
+	"github.com/inconshreveable/log15"
+	"golang.org/x/sync/errgroup"
+)
+
+// ToolVersion is part of every conversion cache key so fragments produced by another build of ds-to-dhall are not
+// reused. It is set by main from the release version.
+var ToolVersion = "dev"
+
+// conversionCache stores the yaml-to-dhall output of single resources in a directory, keyed by the content hash of
+// the resource, its Dhall type, the tool version and the yaml-to-dhall version. A cache without a directory converts
+// every resource.
+type conversionCache struct {
+	dir              string
+	converterVersion string
+
+	hits   int32
+	misses int32
+}
+
+func newConversionCache(ctx context.Context, dir string) (*conversionCache, error) {
+	if dir == "" {
+		return &conversionCache{}, nil
+	}
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	// dev builds all share a ToolVersion, the converter version still invalidates fragments when yaml-to-dhall changes
+	converterVersion, err := yamlToDhallVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the yaml-to-dhall version: %w", err)
+	}
+	return &conversionCache{dir: dir, converterVersion: converterVersion}, nil
+}
+
+func cacheKey(r *comkir.Resource, yamlBytes []byte, converterVersion string) string {
+	h := sha256.New()
+	h.Write(yamlBytes)
+	h.Write([]byte{0})
+	h.Write([]byte(r.DhallType))
+	h.Write([]byte{0})
+	h.Write([]byte(ToolVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(converterVersion))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *conversionCache) fragmentPath(key string) string {
+	return filepath.Join(c.dir, key+".dhall")
+}
+
+// fragment returns the Dhall expression for the resource, running yaml-to-dhall only when the cache has no entry
+// for it.
+func (c *conversionCache) fragment(ctx context.Context, r *comkir.Resource) (string, error) {
+	yamlBytes, err := buildYaml(r.Contents)
+	if err != nil {
+		return "", err
+	}
+
+	if c.dir == "" {
+		atomic.AddInt32(&c.misses, 1)
+		contents, err := yamlToDhallFragment(ctx, r.DhallType, yamlBytes)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert %s: %w", r.Source, err)
+		}
+		return string(contents), nil
+	}
+
+	path := c.fragmentPath(cacheKey(r, yamlBytes, c.converterVersion))
+
+	contents, err := ioutil.ReadFile(path)
+	if err == nil {
+		atomic.AddInt32(&c.hits, 1)
+		return string(contents), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	atomic.AddInt32(&c.misses, 1)
+	contents, err = yamlToDhallFragment(ctx, r.DhallType, yamlBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert %s: %w", r.Source, err)
+	}
+
+	// write to a temp file first so that an interrupted run never leaves a truncated fragment behind
+	tmpFile, err := ioutil.TempFile(c.dir, "fragment-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(contents)
+	if err != nil {
+		tmpFile.Close()
+		return "", err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	return string(contents), nil
+}
+
+func yamlToDhallVersion(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "yaml-to-dhall", "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func yamlToDhallFragment(ctx context.Context, dhallType string, yamlBytes []byte) ([]byte, error) {
+	var outBuf bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "yaml-to-dhall", dhallType, "--records-loose")
+	cmd.Stdin = bytes.NewReader(yamlBytes)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	return outBuf.Bytes(), nil
+}
+
+// convertResources converts every resource of the set through the cache, running at most numConcurrent
+// conversions at the same time.
+func convertResources(ctx context.Context, rs *comkir.ResourceSet, cache *conversionCache,
+	numConcurrent int) (map[*comkir.Resource]string, error) {
+	spin := console.NewSpinner("Converting resources: ")
+	spin.Start()
+	defer spin.Stop()
+
+	var mu sync.Mutex
+	fragments := make(map[*comkir.Resource]string)
+
+	errs, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, numConcurrent)
+
+	var queueErr error
+queue:
+	for _, resources := range rs.Components {
+		for _, r := range resources {
+			r := r
+
+			// stop queueing once a conversion failed, Wait returns its error
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				queueErr = ctx.Err()
+				break queue
+			}
+			errs.Go(func() error {
+				defer func() {
+					<-sem
+				}()
+				fragment, err := cache.fragment(ctx, r)
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				fragments[r] = fragment
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+
+	err := errs.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if queueErr != nil {
+		return nil, queueErr
+	}
+
+	log15.Info("converted resources", "cached", cache.hits, "converted", cache.misses)
+
+	return fragments, nil
+}
+
+var simpleLabel = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_/-]*$`)
+
+// dhallReserved lists the keywords and builtins that cannot be used as unquoted record labels.
+var dhallReserved = map[string]bool{
+	"if": true, "then": true, "else": true, "let": true, "in": true, "as": true, "using": true, "merge": true,
+	"missing": true, "Infinity": true, "NaN": true, "Some": true, "toMap": true, "assert": true, "forall": true,
+	"with": true, "Type": true, "Kind": true, "Sort": true, "Bool": true, "True": true, "False": true,
+	"Optional": true, "None": true, "Natural": true, "Integer": true, "Double": true, "Text": true, "List": true,
+}
+
+// dhallLabel returns s as a Dhall record label, quoting it with backticks when necessary.
+func dhallLabel(s string) string {
+	if simpleLabel.MatchString(s) && !dhallReserved[s] {
+		return s
+	}
+	return "`" + s + "`"
+}
+
+// composeRecord assembles the component -> kind -> name record from per-resource Dhall fragments. The output
+// is deterministic so that unchanged inputs produce an unchanged record.
+func composeRecord(rs *comkir.ResourceSet, fragments map[*comkir.Resource]string) string {
+	components := make([]string, 0, len(rs.Components))
+	for component := range rs.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	var b strings.Builder
+	b.WriteString("{ ")
+	for i, component := range components {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s = ", dhallLabel(component))
+		composeComponent(&b, rs.Components[component], func(r *comkir.Resource) string {
+			return strings.TrimSpace(fragments[r])
+		})
+	}
+	b.WriteString(" }\n")
+
+	return b.String()
+}
+
+// composeComponent writes the kind -> name record of a component, using value to produce the Dhall expression of
+// each resource.
+func composeComponent(b *strings.Builder, resources []*comkir.Resource, value func(*comkir.Resource) string) {
+	byKind := make(map[string][]*comkir.Resource)
+	var kinds []string
+	for _, r := range resources {
+		if _, ok := byKind[r.Kind]; !ok {
+			kinds = append(kinds, r.Kind)
+		}
+		byKind[r.Kind] = append(byKind[r.Kind], r)
+	}
+	sort.Strings(kinds)
+
+	b.WriteString("{ ")
+	for i, kind := range kinds {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		rsOfKind := byKind[kind]
+		sort.Slice(rsOfKind, func(i, j int) bool {
+			return rsOfKind[i].Name < rsOfKind[j].Name
+		})
+
+		fmt.Fprintf(b, "%s = { ", dhallLabel(kind))
+		for j, r := range rsOfKind {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(b, "%s = %s", dhallLabel(r.Name), value(r))
+		}
+		b.WriteString(" }")
+	}
+	b.WriteString(" }")
+}
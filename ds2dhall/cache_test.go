@@ -0,0 +1,56 @@
+package ds2dhall
+
+import (
+	"testing"
+
+	"ds-to-dhall/comkir"
+)
+
+func TestComposeRecord(t *testing.T) {
+	svc := &comkir.Resource{Component: "indexed-search", Kind: "Service", Name: "indexed-search"}
+	indexer := &comkir.Resource{Component: "indexed-search", Kind: "Service", Name: "indexed-search-indexer"}
+	sts := &comkir.Resource{Component: "indexed-search", Kind: "StatefulSet", Name: "indexed-search"}
+	cm := &comkir.Resource{Component: "Frontend", Kind: "ConfigMap", Name: "frontend.config"}
+
+	rs := &comkir.ResourceSet{
+		Components: map[string][]*comkir.Resource{
+			"indexed-search": {sts, indexer, svc},
+			"Frontend":       {cm},
+		},
+	}
+	fragments := map[*comkir.Resource]string{
+		svc:     "{ a = 1 }\n",
+		indexer: "{ a = 2 }\n",
+		sts:     "{ a = 3 }\n",
+		cm:      "{ a = 4 }\n",
+	}
+
+	expected := "{ Frontend = { ConfigMap = { `frontend.config` = { a = 4 } } }, " +
+		"indexed-search = { Service = { indexed-search = { a = 1 }, indexed-search-indexer = { a = 2 } }, " +
+		"StatefulSet = { indexed-search = { a = 3 } } } }\n"
+
+	got := composeRecord(rs, fragments)
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	r := &comkir.Resource{DhallType: "./Service.dhall"}
+	k1 := cacheKey(r, []byte("kind: Service\n"), "1.7.6")
+
+	if k1 != cacheKey(r, []byte("kind: Service\n"), "1.7.6") {
+		t.Errorf("cache key is not stable")
+	}
+	if k1 == cacheKey(r, []byte("kind: Service\nspec: {}\n"), "1.7.6") {
+		t.Errorf("cache key does not depend on contents")
+	}
+	if k1 == cacheKey(r, []byte("kind: Service\n"), "1.7.7") {
+		t.Errorf("cache key does not depend on the yaml-to-dhall version")
+	}
+
+	r.DhallType = "./ConfigMap.dhall"
+	if k1 == cacheKey(r, []byte("kind: Service\n"), "1.7.6") {
+		t.Errorf("cache key does not depend on dhall type")
+	}
+}
@@ -0,0 +1,219 @@
+package ds2dhall
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"ds-to-dhall/comkir"
+)
+
+// cleanRule removes a field from the manifests exported from a cluster. fields is the path of the field, fields
+// ending in [] are lists the rule applies to every item of.
+type cleanRule struct {
+	fields []string
+	// match reports whether the value of the field is removed, nil removes any value
+	match func(interface{}) bool
+	// defaulted rules remove values the API server defaults fields to, the others remove server managed fields
+	defaulted bool
+}
+
+func serverField(fields ...string) cleanRule {
+	return cleanRule{fields: fields}
+}
+
+func defaultValue(value interface{}, fields ...string) cleanRule {
+	return cleanRule{fields: fields, defaulted: true, match: func(v interface{}) bool {
+		return reflect.DeepEqual(v, value)
+	}}
+}
+
+// name is the last field of the rule, which --keep refers to.
+func (r cleanRule) name() string {
+	return strings.TrimSuffix(r.fields[len(r.fields)-1], "[]")
+}
+
+// metadataRules apply to the metadata of every resource.
+var metadataRules = []cleanRule{
+	serverField("status"),
+	serverField("metadata", "uid"),
+	serverField("metadata", "resourceVersion"),
+	serverField("metadata", "generation"),
+	serverField("metadata", "creationTimestamp"),
+	serverField("metadata", "deletionTimestamp"),
+	serverField("metadata", "deletionGracePeriodSeconds"),
+	serverField("metadata", "selfLink"),
+	serverField("metadata", "managedFields"),
+	serverField("metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration"),
+	serverField("metadata", "annotations", "deployment.kubernetes.io/revision"),
+	serverField("metadata", "annotations", "deprecated.daemonset.template.generation"),
+	serverField("metadata", "annotations", "pv.kubernetes.io/bind-completed"),
+	serverField("metadata", "annotations", "pv.kubernetes.io/bound-by-controller"),
+	serverField("metadata", "annotations", "volume.beta.kubernetes.io/storage-provisioner"),
+}
+
+// podRules apply to the pod spec of workloads.
+var podRules = []cleanRule{
+	defaultValue("ClusterFirst", "dnsPolicy"),
+	defaultValue("Always", "restartPolicy"),
+	defaultValue("default-scheduler", "schedulerName"),
+	defaultValue(map[string]interface{}{}, "securityContext"),
+	defaultValue(30, "terminationGracePeriodSeconds"),
+	defaultValue(420, "volumes[]", "configMap", "defaultMode"),
+	defaultValue(420, "volumes[]", "secret", "defaultMode"),
+}
+
+// containerRules apply to the init containers and containers of the pod spec of workloads.
+var containerRules = []cleanRule{
+	defaultValue("/dev/termination-log", "terminationMessagePath"),
+	defaultValue("File", "terminationMessagePolicy"),
+	defaultValue(map[string]interface{}{}, "resources"),
+	defaultValue("TCP", "ports[]", "protocol"),
+}
+
+// probeRules apply to the probes of containers.
+var probeRules = []cleanRule{
+	defaultValue(1, "timeoutSeconds"),
+	defaultValue(10, "periodSeconds"),
+	defaultValue(1, "successThreshold"),
+	defaultValue(3, "failureThreshold"),
+}
+
+// kindRules apply to the resources of a kind.
+var kindRules = map[string][]cleanRule{
+	"Deployment": {
+		defaultValue(600, "spec", "progressDeadlineSeconds"),
+		defaultValue(10, "spec", "revisionHistoryLimit"),
+	},
+	"StatefulSet": {
+		defaultValue("OrderedReady", "spec", "podManagementPolicy"),
+		defaultValue(10, "spec", "revisionHistoryLimit"),
+		serverField("spec", "volumeClaimTemplates[]", "status"),
+		serverField("spec", "volumeClaimTemplates[]", "metadata", "creationTimestamp"),
+		defaultValue("Filesystem", "spec", "volumeClaimTemplates[]", "spec", "volumeMode"),
+	},
+	"DaemonSet": {
+		defaultValue(10, "spec", "revisionHistoryLimit"),
+	},
+	"CronJob": {
+		serverField("spec", "jobTemplate", "metadata", "creationTimestamp"),
+		defaultValue("Allow", "spec", "concurrencyPolicy"),
+		defaultValue(1, "spec", "failedJobsHistoryLimit"),
+		defaultValue(3, "spec", "successfulJobsHistoryLimit"),
+		defaultValue(false, "spec", "suspend"),
+	},
+	"Service": {
+		// headless services have a clusterIP of None, which is not assigned by the API server
+		{fields: []string{"spec", "clusterIP"}, match: func(v interface{}) bool { return v != "None" }},
+		{fields: []string{"spec", "clusterIPs"}, match: func(v interface{}) bool {
+			return !reflect.DeepEqual(v, []interface{}{"None"})
+		}},
+		defaultValue("None", "spec", "sessionAffinity"),
+		defaultValue("ClusterIP", "spec", "type"),
+		defaultValue("TCP", "spec", "ports[]", "protocol"),
+	},
+	"PersistentVolumeClaim": {
+		serverField("spec", "volumeName"),
+		defaultValue("Filesystem", "spec", "volumeMode"),
+	},
+}
+
+// cleaner removes the fields the API server manages, and optionally the fields it defaults, from the manifests of a
+// cluster dump, like the output of kubectl get -o yaml.
+type cleaner struct {
+	defaults bool
+	// keep are the names of the fields that are kept
+	keep map[string]bool
+}
+
+func newCleaner(defaults bool, keep []string) *cleaner {
+	c := &cleaner{defaults: defaults, keep: make(map[string]bool)}
+	for _, k := range keep {
+		c.keep[k] = true
+	}
+	return c
+}
+
+func joinPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// apply removes the field of the rule at fields below m, and appends the paths of the removed fields to removed.
+func (c *cleaner) apply(rule cleanRule, m map[string]interface{}, fields []string, path string, removed *[]string) {
+	field := fields[0]
+	if len(fields) == 1 {
+		v, ok := m[field]
+		if ok && (rule.match == nil || rule.match(v)) {
+			delete(m, field)
+			*removed = append(*removed, joinPath(path, field))
+		}
+		return
+	}
+
+	if strings.HasSuffix(field, "[]") {
+		name := strings.TrimSuffix(field, "[]")
+		items, _ := m[name].([]interface{})
+		for i, item := range items {
+			if child, ok := item.(map[string]interface{}); ok {
+				c.apply(rule, child, fields[1:], fmt.Sprintf("%s[%d]", joinPath(path, name), i), removed)
+			}
+		}
+		return
+	}
+	if child, ok := m[field].(map[string]interface{}); ok {
+		c.apply(rule, child, fields[1:], joinPath(path, field), removed)
+	}
+}
+
+func (c *cleaner) applyAll(rules []cleanRule, m map[string]interface{}, path string, removed *[]string) {
+	for _, rule := range rules {
+		if c.keep[rule.name()] || (rule.defaulted && !c.defaults) {
+			continue
+		}
+		c.apply(rule, m, rule.fields, path, removed)
+	}
+}
+
+// clean removes the fields of the rules from the resource and returns the paths of the removed fields.
+func (c *cleaner) clean(res *comkir.Resource) []string {
+	var removed []string
+	c.applyAll(metadataRules, res.Contents, "", &removed)
+	c.applyAll(kindRules[res.Kind], res.Contents, "", &removed)
+
+	if spec, specPath, ok := res.PodSpec(); ok {
+		// the pod template of a dump has a creationTimestamp of null
+		fields := strings.Split(specPath, ".")
+		templatePath := strings.Join(fields[:len(fields)-1], ".")
+		if template, ok := comkir.MapField(res.Contents, fields[:len(fields)-1]...); ok && res.Kind != "Pod" {
+			c.applyAll([]cleanRule{serverField("metadata", "creationTimestamp")}, template, templatePath, &removed)
+		}
+
+		c.applyAll(podRules, spec, specPath, &removed)
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, _ := spec[field].([]interface{})
+			for i, item := range containers {
+				container, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				containerPath := fmt.Sprintf("%s.%s[%d]", specPath, field, i)
+				c.applyAll(containerRules, container, containerPath, &removed)
+				for _, probe := range []string{"livenessProbe", "readinessProbe", "startupProbe"} {
+					if p, ok := container[probe].(map[string]interface{}); ok {
+						c.applyAll(probeRules, p, containerPath+"."+probe, &removed)
+					}
+				}
+			}
+		}
+	}
+
+	// annotations that only held server managed fields are left empty
+	if annotations, ok := comkir.MapField(res.Contents, "metadata", "annotations"); ok && len(annotations) == 0 {
+		metadata, _ := comkir.MapField(res.Contents, "metadata")
+		delete(metadata, "annotations")
+	}
+	return removed
+}
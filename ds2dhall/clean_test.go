@@ -0,0 +1,105 @@
+package ds2dhall
+
+import (
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir"
+	"gopkg.in/yaml.v3"
+)
+
+const exportedDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+  creationTimestamp: "2020-10-01T12:00:00Z"
+  generation: 3
+  labels:
+    app.kubernetes.io/component: frontend
+  managedFields:
+  - manager: kubectl
+  name: frontend
+  resourceVersion: "1234"
+  uid: 6c5a7a0e-1d43-4c1c-9f3b-1e4d2f8c9a10
+spec:
+  progressDeadlineSeconds: 600
+  replicas: 2
+  revisionHistoryLimit: 5
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: sourcegraph-frontend
+    spec:
+      containers:
+      - name: frontend
+        image: sourcegraph/frontend:3.21.0
+        ports:
+        - containerPort: 3080
+          protocol: TCP
+        readinessProbe:
+          httpGet: {path: /healthz, port: http}
+          periodSeconds: 5
+          timeoutSeconds: 1
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File
+      dnsPolicy: ClusterFirst
+      securityContext: {}
+      terminationGracePeriodSeconds: 60
+status:
+  replicas: 2
+`
+
+func TestClean(t *testing.T) {
+	load := func() *comkir.Resource {
+		res := &comkir.Resource{Kind: "Deployment"}
+		err := yaml.Unmarshal([]byte(exportedDeployment), &res.Contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := load()
+	removed := newCleaner(false, []string{"generation"}).clean(res)
+	expected := "status metadata.uid metadata.resourceVersion metadata.creationTimestamp metadata.managedFields " +
+		"metadata.annotations.kubectl.kubernetes.io/last-applied-configuration " +
+		"metadata.annotations.deployment.kubernetes.io/revision spec.template.metadata.creationTimestamp"
+	if strings.Join(removed, " ") != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, strings.Join(removed, " "))
+	}
+	metadata, _ := comkir.MapField(res.Contents, "metadata")
+	if _, ok := metadata["annotations"]; ok {
+		t.Errorf("expected the emptied annotations to be removed")
+	}
+	if metadata["generation"] != 3 {
+		t.Errorf("expected --keep generation to keep metadata.generation")
+	}
+
+	res = load()
+	removed = newCleaner(true, nil).clean(res)
+	expected = "status metadata.uid metadata.resourceVersion metadata.generation metadata.creationTimestamp " +
+		"metadata.managedFields metadata.annotations.kubectl.kubernetes.io/last-applied-configuration " +
+		"metadata.annotations.deployment.kubernetes.io/revision spec.progressDeadlineSeconds " +
+		"spec.template.metadata.creationTimestamp spec.template.spec.dnsPolicy spec.template.spec.securityContext " +
+		"spec.template.spec.containers[0].terminationMessagePath spec.template.spec.containers[0].terminationMessagePolicy " +
+		"spec.template.spec.containers[0].ports[0].protocol spec.template.spec.containers[0].readinessProbe.timeoutSeconds"
+	if strings.Join(removed, " ") != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, strings.Join(removed, " "))
+	}
+	spec, _, _ := res.PodSpec()
+	if spec["terminationGracePeriodSeconds"] != 60 {
+		t.Errorf("expected a terminationGracePeriodSeconds that is not the default to be kept")
+	}
+
+	service := &comkir.Resource{Kind: "Service", Contents: map[string]interface{}{
+		"spec": map[string]interface{}{"clusterIP": "None", "type": "ClusterIP"},
+	}}
+	removed = newCleaner(true, nil).clean(service)
+	if strings.Join(removed, " ") != "spec.type" {
+		t.Errorf("expected the clusterIP of a headless service to be kept, got %v", removed)
+	}
+}
@@ -0,0 +1,336 @@
+package ds2dhall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/scanner"
+	"unicode"
+)
+
+type typeKind int
+
+const (
+	tText typeKind = iota
+	tNatural
+	tInteger
+	tDouble
+	tBool
+	tOptional
+	tList
+	tRecord
+	tUnion
+	tNamed
+)
+
+var builtinTypes = map[string]typeKind{
+	"Text":    tText,
+	"Natural": tNatural,
+	"Integer": tInteger,
+	"Double":  tDouble,
+	"Bool":    tBool,
+}
+
+type dhallField struct {
+	label string
+	typ   *dhallType // nil for union alternatives without a value
+}
+
+// dhallType is the subset of Dhall types used by the dhall-kubernetes type files.
+type dhallType struct {
+	kind typeKind
+	// elem is the element type of Optional and List
+	elem *dhallType
+	// fields are the fields of a record or the alternatives of a union
+	fields []dhallField
+	// file is the base name of the type file of a named type and name its key in types.dhall
+	file string
+	name string
+}
+
+func (t *dhallType) field(label string) (*dhallType, bool) {
+	for _, f := range t.fields {
+		if f.label == label {
+			return f.typ, true
+		}
+	}
+	return nil, false
+}
+
+type typeParser struct {
+	scnr   *scanner.Scanner
+	peeked string
+	err    error
+}
+
+func (p *typeParser) consume() string {
+	if p.peeked != "" {
+		r := p.peeked
+		p.peeked = ""
+		return r
+	}
+	tk := p.scnr.Scan()
+	if tk == scanner.EOF {
+		return ""
+	}
+	return p.scnr.TokenText()
+}
+
+func (p *typeParser) peek() string {
+	if p.peeked == "" {
+		tk := p.scnr.Scan()
+		if tk == scanner.EOF {
+			return ""
+		}
+		p.peeked = p.scnr.TokenText()
+	}
+	return p.peeked
+}
+
+func (p *typeParser) parseToken(token string) {
+	if p.err != nil {
+		return
+	}
+
+	t := p.consume()
+	if t != token {
+		p.err = fmt.Errorf("%s: expected %s, got %q", p.scnr.Position, token, t)
+	}
+}
+
+func (p *typeParser) parseLabel() string {
+	if p.err != nil {
+		return ""
+	}
+
+	t := p.consume()
+	if t == "" {
+		p.err = fmt.Errorf("%s: unexpected EOF", p.scnr.Position)
+		return ""
+	}
+	return strings.Trim(t, "`")
+}
+
+func (p *typeParser) parseType() *dhallType {
+	if p.err != nil {
+		return nil
+	}
+
+	switch p.peek() {
+	case "Optional":
+		p.consume()
+		return &dhallType{kind: tOptional, elem: p.parseAtom()}
+	case "List":
+		p.consume()
+		return &dhallType{kind: tList, elem: p.parseAtom()}
+	}
+	return p.parseAtom()
+}
+
+func (p *typeParser) parseAtom() *dhallType {
+	if p.err != nil {
+		return nil
+	}
+
+	t := p.consume()
+	switch {
+	case t == "(":
+		inner := p.parseType()
+		p.parseToken(")")
+		return inner
+	case t == "{":
+		return p.parseRecord()
+	case t == "<":
+		return p.parseUnion()
+	case strings.HasPrefix(t, "./") || strings.HasPrefix(t, "../"):
+		if p.peek() == "sha256" {
+			p.consume()
+			p.parseToken(":")
+			p.consume()
+		}
+		return &dhallType{kind: tNamed, file: path.Base(t)}
+	}
+
+	if k, ok := builtinTypes[t]; ok {
+		return &dhallType{kind: k}
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("%s: unsupported type expression %q", p.scnr.Position, t)
+	}
+	return nil
+}
+
+func (p *typeParser) parseRecord() *dhallType {
+	rec := &dhallType{kind: tRecord}
+
+	for p.err == nil && p.peek() != "}" {
+		if len(rec.fields) > 0 {
+			p.parseToken(",")
+		}
+		label := p.parseLabel()
+		p.parseToken(":")
+		rec.fields = append(rec.fields, dhallField{label: label, typ: p.parseType()})
+	}
+	p.parseToken("}")
+
+	return rec
+}
+
+func (p *typeParser) parseUnion() *dhallType {
+	union := &dhallType{kind: tUnion}
+
+	for p.err == nil && p.peek() != ">" {
+		if len(union.fields) > 0 {
+			p.parseToken("|")
+		}
+		alt := dhallField{label: p.parseLabel()}
+		if p.peek() == ":" {
+			p.consume()
+			alt.typ = p.parseType()
+		}
+		union.fields = append(union.fields, alt)
+	}
+	p.parseToken(">")
+
+	return union
+}
+
+// parseDhallType parses the contents of a dhall-kubernetes type file.
+func parseDhallType(filename string, data []byte) (*dhallType, error) {
+	var s scanner.Scanner
+	s.Init(bytes.NewReader(data))
+	s.Filename = filename
+	s.Mode = scanner.ScanIdents | scanner.ScanRawStrings
+	s.IsIdentRune = func(ch rune, i int) bool {
+		return ch == '.' || ch == '_' || unicode.IsLetter(ch) || (ch == '/' || ch == '-' || unicode.IsDigit(ch)) && i > 0
+	}
+
+	p := &typeParser{scnr: &s}
+	t := p.parseType()
+	if p.err == nil && p.peek() != "" {
+		p.err = fmt.Errorf("%s: unexpected %q after type", s.Position, p.peek())
+	}
+
+	return t, p.err
+}
+
+// typeLoader loads and parses dhall-kubernetes types and evaluates the defaults of their schemas on demand.
+type typeLoader struct {
+	baseURL string
+	fetch   func(url string) ([]byte, error)
+	// evaluate returns the JSON value of the Dhall expression at url
+	evaluate func(url string) ([]byte, error)
+
+	// names maps type file base names to their key in types.dhall
+	names    map[string]string
+	files    map[string]string
+	types    map[string]*dhallType
+	defaults map[string]map[string]interface{}
+}
+
+func newTypeLoader(baseURL string, kind2type map[string]string) *typeLoader {
+	l := &typeLoader{
+		baseURL:  baseURL,
+		fetch:    loadContents,
+		evaluate: dhallToJSON,
+		names:    make(map[string]string),
+		files:    make(map[string]string),
+		types:    make(map[string]*dhallType),
+		defaults: make(map[string]map[string]interface{}),
+	}
+	for name, file := range kind2type {
+		l.names[path.Base(file)] = name
+		l.files[name] = path.Base(file)
+	}
+	return l
+}
+
+// named returns the named type with the given key in types.dhall.
+func (l *typeLoader) named(name string) (*dhallType, error) {
+	file, ok := l.files[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown kubernetes type %s", name)
+	}
+	return &dhallType{kind: tNamed, file: file, name: name}, nil
+}
+
+// resolve returns the definition of a named type and t itself for all other types.
+func (l *typeLoader) resolve(t *dhallType) (*dhallType, error) {
+	if t.kind != tNamed {
+		return t, nil
+	}
+	if t.name == "" {
+		t.name = l.names[t.file]
+	}
+
+	if def, ok := l.types[t.file]; ok {
+		return def, nil
+	}
+
+	contents, err := l.fetch(l.baseURL + "/types/" + t.file)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDhallType(t.file, contents)
+	if err != nil {
+		return nil, err
+	}
+	if def.kind == tNamed {
+		def, err = l.resolve(def)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.types[t.file] = def
+
+	return def, nil
+}
+
+// schemaDefaults returns the fields set by the schema default of a named type, like apiVersion and kind, as
+// evaluated by dhall-to-json. Types without a defaults file have no defaults.
+func (l *typeLoader) schemaDefaults(t *dhallType) (map[string]interface{}, error) {
+	if d, ok := l.defaults[t.file]; ok {
+		return d, nil
+	}
+
+	d := make(map[string]interface{})
+	url := l.baseURL + "/defaults/" + t.file
+	if _, err := l.fetch(url); err == nil {
+		contents, err := l.evaluate(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate the default of %s: %w", t.file, err)
+		}
+		err = json.Unmarshal(contents, &d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the default of %s: %w", t.file, err)
+		}
+	}
+	l.defaults[t.file] = d
+
+	return d, nil
+}
+
+// dhallToJSON evaluates the Dhall expression at url with dhall-to-json.
+func dhallToJSON(url string) ([]byte, error) {
+	expr := url
+	if !strings.HasPrefix(url, "http") && !filepath.IsAbs(url) {
+		expr = dhallImport(filepath.ToSlash(filepath.Clean(url)))
+	}
+
+	var outBuf, errBuf bytes.Buffer
+
+	cmd := exec.Command("dhall-to-json")
+	cmd.Stdin = strings.NewReader(expr)
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+	return outBuf.Bytes(), nil
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,7 +17,9 @@ import (
 	"time"
 
 	"ds-to-dhall/comkir"
-	"github.com/briandowns/spinner"
+	"ds-to-dhall/console"
+	"ds-to-dhall/dhall2ds"
+	"ds-to-dhall/openapi"
 	"github.com/inconshreveable/log15"
 	gitignore "github.com/sabhiram/go-gitignore"
 	flag "github.com/spf13/pflag"
@@ -34,6 +37,19 @@ var (
 	timeout         time.Duration
 	ignoreFiles     []string
 	k8sURL          string
+	cacheDir        string
+	splitDir        string
+	splitResources  bool
+	completion      bool
+	extract         []string
+	paramsFile      string
+	imagesFile      string
+	validateSpec    string
+	clean           bool
+	cleanDefaults   bool
+	keepFields      []string
+
+	numConcurrentConversions int
 
 	printHelp bool
 
@@ -42,10 +58,11 @@ var (
 
 const ShortDescription = "imports a deploy-sourcegraph/base into a COMKIR Dhall record"
 
-func Main(args []string, mainCtx context.Context) {
+// Flags returns the flag set of ds2dhall, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
 	flagSet = flag.NewFlagSet("ds2dhall", flag.ExitOnError)
 
-	flagSet.StringVarP(&destinationFile, "output", "o", "", "(required) dhall output file")
+	flagSet.StringVarP(&destinationFile, "output", "o", "", "(required unless --split-dir is given) dhall output file")
 	flagSet.StringVarP(&typeFile, "type", "t", "", "dhall output type file")
 	flagSet.StringVarP(&typesUnionFile, "typesUnion", "x", "", "dhall output types union file")
 	flagSet.StringVarP(&schemaFile, "schema", "s", "", "dhall output schema file")
@@ -54,28 +71,90 @@ func Main(args []string, mainCtx context.Context) {
 	flagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
 	flagSet.StringVarP(&k8sURL, "k8sURL", "u",
 		"https://raw.githubusercontent.com/dhall-lang/dhall-kubernetes/a4126b7f8f0c0935e4d86f0f596176c41efbe6fe/1.18", "URL to k8s Dhall")
+	flagSet.StringVar(&cacheDir, "cache", "",
+		"directory caching the Dhall conversion of each resource. only resources that changed since the last run are converted")
+	flagSet.IntVar(&numConcurrentConversions, "numSimultaneousConversions", 5,
+		"how many resources are converted simultaneously when using --cache or --split-dir")
+	flagSet.StringVar(&splitDir, "split-dir", "",
+		"write the record as a Dhall package with one file per component and a package.dhall assembling them")
+	flagSet.BoolVar(&splitResources, "split-resources", false, "with --split-dir, also write one file per resource")
+	flagSet.BoolVar(&completion, "completion", false,
+		"emit resources as dhall-kubernetes schema completions (Schema::{ ... }) with only the fields that differ from the schema defaults")
+	flagSet.StringArrayVarP(&extract, "extract", "e", nil,
+		"extract the values matching this selector (e.g. '*.Deployment.*.spec.replicas') into params and make the record a function of them")
+	flagSet.StringVar(&paramsFile, "params", "", "(required with --extract) dhall output file for the params type and their current values")
+	flagSet.StringVar(&imagesFile, "images", "",
+		"dhall output file for a dockerimg images record of all container images. the record refers to its entries instead of image literals")
+	flagSet.StringVar(&validateSpec, "validate", "",
+		"validate the resources against this Kubernetes OpenAPI spec (swagger.json) before converting them")
+	flagSet.BoolVar(&clean, "clean", false,
+		"remove the fields the API server manages, like status and metadata.managedFields, from manifests exported from a cluster")
+	flagSet.BoolVar(&cleanDefaults, "clean-defaults", false,
+		"with --clean, also remove the fields that have the value the API server defaults them to")
+	flagSet.StringArrayVar(&keepFields, "keep", nil,
+		"with --clean, keep the fields with this name, like status or terminationGracePeriodSeconds")
 	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
 
 	flagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "ds2dhall %s\n", ShortDescription)
-		fmt.Fprintf(os.Stderr, "Usage of ds-to-dhall ds2dhall: --output <output> <path>...\n")
-		fmt.Fprintln(os.Stderr, "OPTIONS:")
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "ds2dhall %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall ds2dhall: --output <output> <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
 		flagSet.PrintDefaults()
-		fmt.Fprintln(os.Stderr, usageArgs())
+		fmt.Fprintln(w, usageArgs())
 	}
 
-	_ = flagSet.Parse(args)
+	return flagSet
+}
+
+func Main(args []string, mainCtx context.Context) {
+	_ = Flags().Parse(args)
 
 	if printHelp {
 		flagSet.Usage()
 		os.Exit(0)
 	}
 
-	if destinationFile == "" {
+	if destinationFile == "" && (splitDir == "" || schemaFile != "") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if numConcurrentConversions < 1 {
+		fmt.Fprintln(os.Stderr, "--numSimultaneousConversions must be at least 1")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if len(extract) > 0 && (paramsFile == "" || destinationFile == "" || splitDir != "" || schemaFile != "") {
+		fmt.Fprintln(os.Stderr, "--extract requires --params and --output and cannot be combined with --split-dir or --schema")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if imagesFile != "" && (destinationFile == "" || splitDir != "") {
+		fmt.Fprintln(os.Stderr, "--images requires --output and cannot be combined with --split-dir")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	if cacheDir != "" && (completion || len(extract) > 0 || imagesFile != "") {
+		fmt.Fprintln(os.Stderr, "--cache cannot be combined with --completion, --extract or --images")
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
+	if (cleanDefaults || len(keepFields) > 0) && !clean {
+		fmt.Fprintln(os.Stderr, "--clean-defaults and --keep require --clean")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	selectors, err := parseSelectors(extract)
+	if err != nil {
+		logFatal("invalid selector", "error", err)
+	}
+
 	inputs := flagSet.Args()
 	if len(inputs) == 0 {
 		cwd, err := os.Getwd()
@@ -92,11 +171,31 @@ func Main(args []string, mainCtx context.Context) {
 	}
 
 	log15.Info("loading resources", "inputs", inputs)
-	srcSet, err := loadResourceSet(inputs, kind2Type)
+	var c *cleaner
+	if clean {
+		c = newCleaner(cleanDefaults, keepFields)
+	}
+	srcSet, err := loadResourceSet(inputs, ignoreFiles, kind2Type, c, nil)
 	if err != nil {
 		logFatal("failed to load source resources", "error", err, "inputs", inputs)
 	}
 
+	if validateSpec != "" {
+		log15.Info("validating resources", "spec", validateSpec)
+
+		spec, err := openapi.LoadSpec(validateSpec)
+		if err != nil {
+			logFatal("failed to load OpenAPI spec", "error", err, "spec", validateSpec)
+		}
+		validationErrors := spec.ValidateResourceSet(srcSet)
+		for _, e := range validationErrors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		if len(validationErrors) > 0 {
+			logFatal("resources do not match the OpenAPI spec", "errors", len(validationErrors))
+		}
+	}
+
 	yamlBytes, err := buildYaml(buildRecord(srcSet))
 	if err != nil {
 		logFatal("failed to compose yaml", "error", err)
@@ -142,23 +241,97 @@ func Main(args []string, mainCtx context.Context) {
 	ctx, cancel := context.WithTimeout(mainCtx, timeout)
 	defer cancel()
 
-	err = yamlToDhall(ctx, dhallType, yamlBytes, destinationFile)
-	if err != nil {
-		logFatal("failed to execute yaml-to-dhall", "error", err)
-	}
+	if completion || len(selectors) > 0 || imagesFile != "" || cacheDir != "" || splitDir != "" {
+		var fragments map[*comkir.Resource]string
+		header := ""
 
-	log15.Info("formatting output")
+		if completion || len(selectors) > 0 || imagesFile != "" {
+			log15.Info("emitting dhall", "completion", completion, "extract", extract, "images", imagesFile)
 
-	err = dhallFormat(destinationFile)
-	if err != nil {
-		logFatal("failed to format dhall file", "error", err, "file", destinationFile)
+			var images *imageExtractor
+			if imagesFile != "" {
+				images, err = newImageExtractor(destinationFile, imagesFile)
+				if err != nil {
+					logFatal("failed to locate images file", "error", err, "imagesFile", imagesFile)
+				}
+			}
+
+			var e *emitter
+			fragments, e, err = emitResources(newTypeLoader(k8sURL, kind2Type), srcSet, completion, selectors, images)
+			if err != nil {
+				logFatal("failed to emit dhall", "error", err)
+			}
+
+			if images != nil {
+				contents, err := images.imagesRecord()
+				if err != nil {
+					logFatal("failed to compose images record", "error", err)
+				}
+
+				err = writeGeneratedDhall(imagesFile, contents)
+				if err != nil {
+					logFatal("failed to write images file", "error", err, "imagesFile", imagesFile)
+				}
+			}
+
+			header = e.header()
+			if len(selectors) > 0 {
+				e.warnUnmatchedSelectors()
+				header = e.functionHeader()
+
+				err = writeGeneratedDhall(paramsFile, e.paramsRecord())
+				if err != nil {
+					logFatal("failed to write params file", "error", err, "paramsFile", paramsFile)
+				}
+			}
+		} else {
+			cache, err := newConversionCache(ctx, cacheDir)
+			if err != nil {
+				logFatal("failed to create cache directory", "error", err, "cache", cacheDir)
+			}
+
+			fragments, err = convertResources(ctx, srcSet, cache, numConcurrentConversions)
+			if err != nil {
+				logFatal("failed to execute yaml-to-dhall", "error", err)
+			}
+		}
+
+		if splitDir != "" {
+			log15.Info("writing dhall package", "directory", splitDir)
+
+			err = writePackage(splitDir, srcSet, fragments, header, dhallType, splitResources)
+			if err != nil {
+				logFatal("failed to write dhall package", "error", err, "directory", splitDir)
+			}
+		}
+
+		if destinationFile != "" {
+			err = ioutil.WriteFile(destinationFile, []byte(header+composeRecord(srcSet, fragments)), 0644)
+			if err != nil {
+				logFatal("failed to write dhall record", "error", err, "destination", destinationFile)
+			}
+		}
+	} else {
+		err = yamlToDhall(ctx, dhallType, yamlBytes, destinationFile)
+		if err != nil {
+			logFatal("failed to execute yaml-to-dhall", "error", err)
+		}
 	}
 
-	log15.Info("prepending generated comment")
+	if destinationFile != "" {
+		log15.Info("formatting output")
 
-	err = prependLine(destinationFile, GeneratedComment)
-	if err != nil {
-		logFatal("failed to prepend generated comment to dhall file", "error", err, "file", destinationFile)
+		err = dhallFormat(destinationFile)
+		if err != nil {
+			logFatal("failed to format dhall file", "error", err, "file", destinationFile)
+		}
+
+		log15.Info("prepending generated comment")
+
+		err = prependLine(destinationFile, GeneratedComment)
+		if err != nil {
+			logFatal("failed to prepend generated comment to dhall file", "error", err, "file", destinationFile)
+		}
 	}
 
 	if schemaFile != "" {
@@ -203,31 +376,28 @@ func Main(args []string, mainCtx context.Context) {
 	log15.Info("done")
 }
 
-func loadHttpContents(url string) ([]byte, error) {
+// loadContents loads url over http or, when it is not a http url, from the local file system.
+func loadContents(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http") {
+		return ioutil.ReadFile(url)
+	}
+
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
 	return ioutil.ReadAll(resp.Body)
 }
 
 func buildKind2TypeMapping(url string) (map[string]string, error) {
-	var typesBytes []byte
-
-	if strings.HasPrefix(url, "http") {
-		contents, err := loadHttpContents(url)
-		if err != nil {
-			return nil, err
-		}
-		typesBytes = contents
-	} else {
-		contents, err := ioutil.ReadFile(url)
-		if err != nil {
-			return nil, err
-		}
-		typesBytes = contents
+	typesBytes, err := loadContents(url)
+	if err != nil {
+		return nil, err
 	}
 
 	return parseTypes(typesBytes)
@@ -241,11 +411,45 @@ func typeRefFromKind(kind string, kind2type map[string]string) string {
 	return dt
 }
 
-func loadResource(rootDir string, filename string, kind2type map[string]string) (*comkir.Resource, error) {
-	relPath, err := filepath.Rel(rootDir, filename)
-	if err != nil {
-		return nil, err
+// isList reports whether the contents of a manifest are a List, like the output of kubectl get -o yaml, or a typed list
+// like DeploymentList.
+func isList(contents map[string]interface{}) bool {
+	kind, _ := contents["kind"].(string)
+	_, hasItems := contents["items"]
+	return strings.HasSuffix(kind, "List") && hasItems
+}
+
+// ResourceError is a manifest, or an item of a List manifest, that cannot be loaded as a resource.
+type ResourceError struct {
+	Source string
+	// Where names the manifest or the list item, like base/all.yaml items[2]
+	Where string
+	// Kind is empty if the manifest has no kind
+	Kind string
+	// Path is the field of the manifest the problem is with, like items[2].metadata.name
+	Path    string
+	Message string
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("resource %s is %s", e.Where, e.Message)
+}
+
+// addProblem appends err to problems if it is a ResourceError and problems is not nil, so that loading continues
+// with the next resource. Other errors are returned.
+func addProblem(problems *[]*ResourceError, err error) error {
+	var re *ResourceError
+	if problems != nil && errors.As(err, &re) {
+		*problems = append(*problems, re)
+		return nil
 	}
+	return err
+}
+
+// loadResources loads the resources of a manifest. Lists are expanded into their items, which become resources of
+// their own. Manifests that are not resources are added to problems unless it is nil.
+func loadResources(rootDir string, filename string, kind2type map[string]string, c *cleaner,
+	problems *[]*ResourceError) ([]*comkir.Resource, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -255,22 +459,85 @@ func loadResource(rootDir string, filename string, kind2type map[string]string)
 	br := bufio.NewReader(f)
 	decoder := yaml.NewDecoder(br)
 
-	var res comkir.Resource
-	res.Source = filename
-	err = decoder.Decode(&res.Contents)
+	var contents map[string]interface{}
+	err = decoder.Decode(&contents)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode yaml file: %s: %v", filename, err)
 	}
 
+	if !isList(contents) {
+		res, err := newResource(rootDir, filename, filename, contents, kind2type, c)
+		if err != nil {
+			return nil, addProblem(problems, err)
+		}
+		return []*comkir.Resource{res}, nil
+	}
+
+	// the items of a typed list like DeploymentList can leave out their kind and apiVersion
+	listKind, _ := contents["kind"].(string)
+	itemKind := strings.TrimSuffix(listKind, "List")
+	items, _ := contents["items"].([]interface{})
+	var resources []*comkir.Resource
+	for i, item := range items {
+		where := fmt.Sprintf("%s items[%d]", filename, i)
+		itemContents, ok := item.(map[string]interface{})
+		if !ok {
+			err := addProblem(problems, &ResourceError{Source: filename, Where: where, Path: fmt.Sprintf("items[%d]", i),
+				Message: "not a mapping"})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, ok := itemContents["kind"]; !ok && itemKind != "" {
+			itemContents["kind"] = itemKind
+		}
+		if _, ok := itemContents["apiVersion"]; !ok && itemKind != "" {
+			itemContents["apiVersion"] = contents["apiVersion"]
+		}
+		res, err := newResource(rootDir, filename, where, itemContents, kind2type, c)
+		var re *ResourceError
+		if errors.As(err, &re) {
+			re.Path = fmt.Sprintf("items[%d].%s", i, re.Path)
+		}
+		if err != nil {
+			err = addProblem(problems, err)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		resources = append(resources, res)
+	}
+	if len(resources) == 0 {
+		log15.Warn("list has no items", "manifest", filename)
+	}
+	return resources, nil
+}
+
+// newResource makes a resource of the contents of a manifest, or of an item of a list in the manifest. where names
+// the manifest or the list item in errors. Contents without kind, apiVersion or name are a ResourceError.
+func newResource(rootDir string, filename string, where string, contents map[string]interface{},
+	kind2type map[string]string, c *cleaner) (*comkir.Resource, error) {
+	relPath, err := filepath.Rel(rootDir, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var res comkir.Resource
+	res.Source = filename
+	res.Contents = contents
+
 	kind, ok := res.Contents["kind"].(string)
 	if !ok {
-		return nil, fmt.Errorf("resource %s is missing a kind field", filename)
+		return nil, &ResourceError{Source: filename, Where: where, Path: "kind", Message: "missing a kind field"}
 	}
 	res.Kind = kind
 
 	apiVersion, ok := res.Contents["apiVersion"].(string)
 	if !ok {
-		return nil, fmt.Errorf("resource %s is missing a apiVersion field", filename)
+		return nil, &ResourceError{Source: filename, Where: where, Kind: kind, Path: "apiVersion",
+			Message: "missing a apiVersion field"}
 	}
 	res.ApiVersion = apiVersion
 
@@ -278,12 +545,13 @@ func loadResource(rootDir string, filename string, kind2type map[string]string)
 
 	metadata, ok := res.Contents["metadata"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("resource %s is missing metadata", filename)
+		return nil, &ResourceError{Source: filename, Where: where, Kind: kind, Path: "metadata", Message: "missing metadata"}
 	}
 
 	name, ok := metadata["name"].(string)
 	if !ok {
-		return nil, fmt.Errorf("resource %s is missing name field", filename)
+		return nil, &ResourceError{Source: filename, Where: where, Kind: kind, Path: "metadata.name",
+			Message: "missing name field"}
 	}
 	res.Name = name
 
@@ -297,14 +565,21 @@ func loadResource(rootDir string, filename string, kind2type map[string]string)
 	if ok {
 		res.Component = componentLabel
 	} else {
-		log15.Warn("deriving component from directory", "manifest", filename)
+		log15.Warn("deriving component from directory", "manifest", where)
 		res.Component = filepath.Dir(relPath)
 		if res.Component == "." {
 			res.Component = filepath.Base(rootDir)
 		}
 	}
 
-	err = patchResource(&res, filename)
+	if c != nil {
+		removed := c.clean(&res)
+		if len(removed) > 0 {
+			log15.Info("cleaned resource", "manifest", where, "removed", strings.Join(removed, " "))
+		}
+	}
+
+	err = patchResource(&res, where)
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +640,36 @@ func commonPrefix(paths []string) (string, error) {
 	return strings.Join(cp, string(os.PathSeparator)), nil
 }
 
-func loadResourceSet(inputs []string, kind2type map[string]string) (*comkir.ResourceSet, error) {
+// LoadResourceSet loads the resources of the YAML files in inputs, skipping the files that match the gitignore
+// patterns of ignore. Resources of kinds missing from kind2type have no DhallType.
+func LoadResourceSet(inputs []string, ignore []string, kind2type map[string]string) (*comkir.ResourceSet, error) {
+	return loadResourceSet(inputs, ignore, kind2type, nil, nil)
+}
+
+// LoadResourceSetProblems is LoadResourceSet that keeps loading past manifests that are not resources, like
+// resources without a name, and returns them as problems.
+func LoadResourceSetProblems(inputs []string, ignore []string) (*comkir.ResourceSet, []*ResourceError, error) {
+	var problems []*ResourceError
+	rs, err := loadResourceSet(inputs, ignore, nil, nil, &problems)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rs, problems, nil
+}
+
+// LoadInputs loads the resources of a COMKIR Dhall record through dhall-to-yaml if inputs is a single .dhall file,
+// and of the YAML files in inputs like LoadResourceSet otherwise.
+func LoadInputs(ctx context.Context, inputs []string, ignore []string) (*comkir.ResourceSet, error) {
+	if len(inputs) == 1 && filepath.Ext(inputs[0]) == ".dhall" {
+		return dhall2ds.LoadResourceSet(ctx, inputs[0])
+	}
+	return LoadResourceSet(inputs, ignore, nil)
+}
+
+// loadResourceSet is LoadResourceSet that removes the fields of c from the resources, unless c is nil, and adds the
+// manifests that are not resources to problems, unless it is nil.
+func loadResourceSet(inputs []string, ignore []string, kind2type map[string]string, c *cleaner,
+	problems *[]*ResourceError) (*comkir.ResourceSet, error) {
 	pas, err := makeAbs(inputs)
 	if err != nil {
 		return nil, err
@@ -377,7 +681,7 @@ func loadResourceSet(inputs []string, kind2type map[string]string) (*comkir.Reso
 	var rs comkir.ResourceSet
 	rs.Components = make(map[string][]*comkir.Resource)
 	rs.Root = cr
-	gitIgnoreMatcher := gitignore.CompileIgnoreLines(ignoreFiles...)
+	gitIgnoreMatcher := gitignore.CompileIgnoreLines(ignore...)
 
 	numResources := 0
 
@@ -399,12 +703,14 @@ func loadResourceSet(inputs []string, kind2type map[string]string) (*comkir.Reso
 			}
 
 			if filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml" {
-				res, err := loadResource(rs.Root, path, kind2type)
+				resources, err := loadResources(rs.Root, path, kind2type, c, problems)
 				if err != nil {
 					return err
 				}
-				rs.Components[res.Component] = append(rs.Components[res.Component], res)
-				numResources++
+				for _, res := range resources {
+					rs.Components[res.Component] = append(rs.Components[res.Component], res)
+				}
+				numResources += len(resources)
 			}
 			return nil
 		})
@@ -484,8 +790,7 @@ func buildYaml(record map[string]interface{}) ([]byte, error) {
 }
 
 func yamlToDhall(ctx context.Context, schema string, yamlBytes []byte, dst string) error {
-	spin := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
-	spin.Prefix = "Running yaml-to-dhall: "
+	spin := console.NewSpinner("Running yaml-to-dhall: ")
 	spin.Start()
 	defer spin.Stop()
 
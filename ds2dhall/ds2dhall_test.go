@@ -0,0 +1,95 @@
+package ds2dhall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadResourceSetLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ds2dhall-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifests := map[string]string{
+		"dump/all.yaml": `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: sourcegraph-frontend
+    labels:
+      app.kubernetes.io/component: frontend
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: prometheus
+metadata:
+  resourceVersion: ""
+`,
+		"dump/deployments.yaml": `
+apiVersion: apps/v1
+kind: DeploymentList
+items:
+- metadata:
+    name: frontend
+    labels:
+      app.kubernetes.io/component: frontend
+  spec: {}
+`,
+		"dump/empty.yaml": "apiVersion: v1\nkind: List\nitems: []\n",
+	}
+	for path, manifest := range manifests {
+		err = os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, path), []byte(manifest), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs, err := LoadResourceSet([]string{dir}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded []string
+	for component, resources := range rs.Components {
+		for _, res := range resources {
+			loaded = append(loaded, component+"."+res.ApiVersion+"."+res.Kind+"."+res.Name)
+		}
+	}
+	sort.Strings(loaded)
+	expected := "dump.v1.ConfigMap.prometheus frontend.apps/v1.Deployment.frontend frontend.v1.Service.sourcegraph-frontend"
+	if strings.Join(loaded, " ") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(loaded, " "))
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "dump/all.yaml"), []byte("apiVersion: v1\nkind: List\nitems:\n- kind: Secret\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadResourceSet([]string{dir}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "all.yaml items[0]") {
+		t.Errorf("expected an error naming the list item, got %v", err)
+	}
+
+	rs, problems, err := LoadResourceSetProblems([]string{dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Kind != "Secret" || problems[0].Path != "items[0].apiVersion" {
+		t.Errorf("expected the list item without apiVersion as a problem, got %v", problems)
+	}
+	if len(rs.Components["frontend"]) != 1 || rs.Components["frontend"][0].Kind != "Deployment" {
+		t.Errorf("expected the other manifests to load, got %v", rs.Components)
+	}
+}
@@ -0,0 +1,516 @@
+package ds2dhall
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ds-to-dhall/comkir"
+	"github.com/inconshreveable/log15"
+)
+
+// dhallExpr is a rendered Dhall expression. Atomic expressions can be passed as function arguments without
+// parentheses.
+type dhallExpr struct {
+	text   string
+	atomic bool
+}
+
+func (x dhallExpr) arg() string {
+	if x.atomic {
+		return x.text
+	}
+	return "(" + x.text + ")"
+}
+
+// emitter renders YAML values as Dhall expressions of dhall-kubernetes types.
+type emitter struct {
+	types *typeLoader
+
+	// completion emits records of named types as schema completions (schemas.<Type>::{ ... }) that only contain the
+	// fields which differ from the schema default, instead of spelling out every field
+	completion bool
+
+	// values at paths matching one of the selectors are extracted into params
+	selectors  []selector
+	params     *paramNode
+	extracting bool
+
+	// container images are extracted into the images record if set
+	images *imageExtractor
+
+	// usesSchemas and usesTypes are set once an expression refers to the schemas.dhall or types.dhall import
+	usesSchemas bool
+	usesTypes   bool
+}
+
+// emitterState is the part of the emitter that emitting a value changes.
+type emitterState struct {
+	params      *paramNode
+	matched     []bool
+	usesSchemas bool
+	usesTypes   bool
+}
+
+func (e *emitter) save() emitterState {
+	s := emitterState{params: e.params.clone(), usesSchemas: e.usesSchemas, usesTypes: e.usesTypes}
+	for _, sel := range e.selectors {
+		s.matched = append(s.matched, sel.matched)
+	}
+	return s
+}
+
+// restore resets the emitter to a saved state. The state can be restored again.
+func (e *emitter) restore(s emitterState) {
+	e.params = s.params.clone()
+	for i := range e.selectors {
+		e.selectors[i].matched = s.matched[i]
+	}
+	e.usesSchemas, e.usesTypes = s.usesSchemas, s.usesTypes
+}
+
+// bindings returns the let bindings that the emitted expressions rely on.
+func (e *emitter) bindings() string {
+	var b string
+	if e.usesSchemas {
+		b += fmt.Sprintf("let schemas = %s/schemas.dhall\n\n", e.types.baseURL)
+	}
+	if e.usesTypes {
+		b += fmt.Sprintf("let types = %s/types.dhall\n\n", e.types.baseURL)
+	}
+	if e.images != nil && len(e.images.imgRefs) > 0 {
+		b += fmt.Sprintf("let images = %s\n\n%s", e.images.importPath, showImage)
+	}
+	return b
+}
+
+// header returns the bindings followed by the start of the let body, or nothing if there are no bindings.
+func (e *emitter) header() string {
+	b := e.bindings()
+	if b == "" {
+		return ""
+	}
+	return b + "in  "
+}
+
+// emit emits the value at path, which is either extracted or rendered by emitValue. Values at the same path that
+// emitValue renders while unwrapping Optional, named and union types are not considered for extraction again.
+func (e *emitter) emit(v interface{}, t *dhallType, path []string) (dhallExpr, error) {
+	if e.images != nil && isImagePath(path) {
+		x, ok, err := e.extractImage(v, t, path)
+		if ok || err != nil {
+			return x, err
+		}
+	}
+	if v != nil && !e.extracting && e.extracted(path) {
+		return e.extract(v, t, path)
+	}
+	return e.emitValue(v, t, path)
+}
+
+func (e *emitter) emitValue(v interface{}, t *dhallType, path []string) (dhallExpr, error) {
+	switch t.kind {
+	case tNamed:
+		def, err := e.types.resolve(t)
+		if err != nil {
+			return dhallExpr{}, err
+		}
+		switch {
+		case def.kind == tRecord && t.name != "" && e.completion:
+			return e.emitCompletion(v, t, def, path)
+		case def.kind == tRecord:
+			defaults, err := e.types.schemaDefaults(t)
+			if err != nil {
+				return dhallExpr{}, err
+			}
+			return e.emitRecord(v, def, defaults, path)
+		case def.kind == tUnion:
+			return e.emitUnion(v, def, e.typeText(t, false), path)
+		}
+		return e.emitValue(v, def, path)
+	case tOptional:
+		if v == nil {
+			return dhallExpr{text: "None " + e.typeText(t.elem, true)}, nil
+		}
+		x, err := e.emitValue(v, t.elem, path)
+		if err != nil {
+			return dhallExpr{}, err
+		}
+		return dhallExpr{text: "Some " + x.arg()}, nil
+	case tList:
+		return e.emitList(v, t, path)
+	case tRecord:
+		return e.emitRecord(v, t, nil, path)
+	case tUnion:
+		return e.emitUnion(v, t, e.typeText(t, true), path)
+	}
+	return emitScalar(v, t.kind, path)
+}
+
+func (e *emitter) emitCompletion(v interface{}, named *dhallType, def *dhallType, path []string) (dhallExpr, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return dhallExpr{}, fmt.Errorf("%s: expected a record, got %T", formatPath(path), v)
+	}
+	defaults, err := e.types.schemaDefaults(named)
+	if err != nil {
+		return dhallExpr{}, err
+	}
+
+	var fields []string
+	for _, f := range def.fields {
+		fv, present := m[f.label]
+		if !present || fv == nil && f.typ.kind == tOptional {
+			_, hasDefault := defaults[f.label]
+			if f.typ.kind != tOptional && !hasDefault {
+				return dhallExpr{}, fmt.Errorf("%s: missing required field %s", formatPath(path), f.label)
+			}
+			continue
+		}
+		if d, hasDefault := defaults[f.label]; hasDefault && sameValue(fv, d) {
+			continue
+		}
+
+		x, err := e.emit(fv, f.typ, appendPath(path, f.label))
+		if err != nil {
+			return dhallExpr{}, err
+		}
+		fields = append(fields, fmt.Sprintf("%s = %s", dhallLabel(f.label), x.text))
+	}
+	warnUnknownFields(m, def, path)
+
+	e.usesSchemas = true
+	if len(fields) == 0 {
+		return dhallExpr{text: fmt.Sprintf("schemas.%s::{=}", named.name), atomic: true}, nil
+	}
+	return dhallExpr{text: fmt.Sprintf("schemas.%s::{ %s }", named.name, strings.Join(fields, ", ")), atomic: true}, nil
+}
+
+// sameValue reports whether a manifest value equals a schema default as decoded from dhall-to-json.
+func sameValue(v interface{}, d interface{}) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	var normalized interface{}
+	err = json.Unmarshal(b, &normalized)
+	return err == nil && reflect.DeepEqual(normalized, d)
+}
+
+// emitRecord spells out every field of the record, using the schema defaults for missing required fields.
+func (e *emitter) emitRecord(v interface{}, t *dhallType, defaults map[string]interface{},
+	path []string) (dhallExpr, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return dhallExpr{}, fmt.Errorf("%s: expected a record, got %T", formatPath(path), v)
+	}
+
+	var fields []string
+	for _, f := range t.fields {
+		fv, present := m[f.label]
+		if !present && f.typ.kind != tOptional {
+			d, hasDefault := defaults[f.label]
+			if !hasDefault {
+				return dhallExpr{}, fmt.Errorf("%s: missing required field %s", formatPath(path), f.label)
+			}
+			fv = d
+		}
+
+		x, err := e.emit(fv, f.typ, appendPath(path, f.label))
+		if err != nil {
+			return dhallExpr{}, err
+		}
+		fields = append(fields, fmt.Sprintf("%s = %s", dhallLabel(f.label), x.text))
+	}
+	warnUnknownFields(m, t, path)
+
+	if len(fields) == 0 {
+		return dhallExpr{text: "{=}", atomic: true}, nil
+	}
+	return dhallExpr{text: "{ " + strings.Join(fields, ", ") + " }", atomic: true}, nil
+}
+
+func warnUnknownFields(m map[string]interface{}, t *dhallType, path []string) {
+	for k := range m {
+		if _, ok := t.field(k); !ok {
+			log15.Warn("dropping field unknown to the dhall type", "path", formatPath(appendPath(path, k)))
+		}
+	}
+}
+
+// isMapEntry reports whether t is the { mapKey : Text, mapValue : T } record used for YAML maps.
+func isMapEntry(t *dhallType) bool {
+	if t.kind != tRecord || len(t.fields) != 2 {
+		return false
+	}
+	keyType, ok := t.field("mapKey")
+	_, hasValue := t.field("mapValue")
+	return ok && hasValue && keyType.kind == tText
+}
+
+func (e *emitter) emitList(v interface{}, t *dhallType, path []string) (dhallExpr, error) {
+	if m, ok := v.(map[string]interface{}); ok && isMapEntry(t.elem) {
+		if len(m) == 0 {
+			return dhallExpr{text: "[] : List " + e.typeText(t.elem, true)}, nil
+		}
+
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		valueType, _ := t.elem.field("mapValue")
+		entries := make([]string, 0, len(keys))
+		for _, k := range keys {
+			x, err := e.emit(m[k], valueType, appendPath(path, k))
+			if err != nil {
+				return dhallExpr{}, err
+			}
+			entries = append(entries, fmt.Sprintf("%s = %s", dhallLabel(k), x.text))
+		}
+		return dhallExpr{text: "toMap { " + strings.Join(entries, ", ") + " }"}, nil
+	}
+
+	l, ok := v.([]interface{})
+	if !ok {
+		return dhallExpr{}, fmt.Errorf("%s: expected a list, got %T", formatPath(path), v)
+	}
+	if len(l) == 0 {
+		return dhallExpr{text: "[] : List " + e.typeText(t.elem, true)}, nil
+	}
+
+	items := make([]string, 0, len(l))
+	for i, item := range l {
+		x, err := e.emit(item, t.elem, appendPath(path, elementKey(item, i)))
+		if err != nil {
+			return dhallExpr{}, err
+		}
+		items = append(items, x.text)
+	}
+	return dhallExpr{text: "[ " + strings.Join(items, ", ") + " ]", atomic: true}, nil
+}
+
+// emitUnion picks the first alternative of the union that can represent v. Alternatives that fail leave no params or
+// bindings behind.
+func (e *emitter) emitUnion(v interface{}, t *dhallType, ref string, path []string) (dhallExpr, error) {
+	state := e.save()
+	for _, alt := range t.fields {
+		if alt.typ == nil {
+			if s, ok := v.(string); ok && s == alt.label {
+				return dhallExpr{text: ref + "." + dhallLabel(alt.label), atomic: true}, nil
+			}
+			continue
+		}
+
+		x, err := e.emitValue(v, alt.typ, path)
+		if err == nil {
+			return dhallExpr{text: fmt.Sprintf("%s.%s %s", ref, dhallLabel(alt.label), x.arg())}, nil
+		}
+		e.restore(state)
+	}
+	return dhallExpr{}, fmt.Errorf("%s: value %v does not match any alternative of %s", formatPath(path), v, ref)
+}
+
+func emitScalar(v interface{}, kind typeKind, path []string) (dhallExpr, error) {
+	switch kind {
+	case tText:
+		switch x := v.(type) {
+		case string:
+			return dhallExpr{text: dhallText(x), atomic: true}, nil
+		case int, int64, uint64, float64, bool:
+			return dhallExpr{text: dhallText(fmt.Sprint(x)), atomic: true}, nil
+		}
+	case tBool:
+		if b, ok := v.(bool); ok {
+			if b {
+				return dhallExpr{text: "True", atomic: true}, nil
+			}
+			return dhallExpr{text: "False", atomic: true}, nil
+		}
+	case tNatural:
+		if n, ok := toInt(v); ok && n >= 0 {
+			return dhallExpr{text: strconv.FormatInt(n, 10), atomic: true}, nil
+		}
+	case tInteger:
+		if n, ok := toInt(v); ok {
+			if n >= 0 {
+				return dhallExpr{text: "+" + strconv.FormatInt(n, 10), atomic: true}, nil
+			}
+			return dhallExpr{text: strconv.FormatInt(n, 10), atomic: true}, nil
+		}
+	case tDouble:
+		f, ok := v.(float64)
+		if n, isInt := toInt(v); isInt {
+			f, ok = float64(n), true
+		}
+		if ok {
+			s := strconv.FormatFloat(f, 'f', -1, 64)
+			if !strings.Contains(s, ".") {
+				s += ".0"
+			}
+			return dhallExpr{text: s, atomic: true}, nil
+		}
+	}
+	return dhallExpr{}, fmt.Errorf("%s: value %v (%T) does not match the dhall type", formatPath(path), v, v)
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint64:
+		if x <= math.MaxInt64 {
+			return int64(x), true
+		}
+	case float64:
+		if x == math.Trunc(x) {
+			return int64(x), true
+		}
+	}
+	return 0, false
+}
+
+// dhallText returns s as a double quoted Dhall text literal.
+func dhallText(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '$':
+			b.WriteString(`\$`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				fmt.Fprintf(&b, `\u%04X`, c)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// typeText renders t. Named types refer to the types.dhall import.
+func (e *emitter) typeText(t *dhallType, arg bool) string {
+	var s string
+	switch t.kind {
+	case tText:
+		return "Text"
+	case tNatural:
+		return "Natural"
+	case tInteger:
+		return "Integer"
+	case tDouble:
+		return "Double"
+	case tBool:
+		return "Bool"
+	case tNamed:
+		if t.name == "" {
+			t.name = e.types.names[t.file]
+		}
+		if t.name != "" {
+			e.usesTypes = true
+			return "types." + t.name
+		}
+		def, err := e.types.resolve(t)
+		if err != nil {
+			// the type is not part of types.dhall and cannot be loaded, let dhall report the problem
+			return t.file
+		}
+		return e.typeText(def, arg)
+	case tOptional:
+		s = "Optional " + e.typeText(t.elem, true)
+	case tList:
+		s = "List " + e.typeText(t.elem, true)
+	case tRecord:
+		fields := make([]string, 0, len(t.fields))
+		for _, f := range t.fields {
+			fields = append(fields, fmt.Sprintf("%s : %s", dhallLabel(f.label), e.typeText(f.typ, false)))
+		}
+		if len(fields) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	case tUnion:
+		alts := make([]string, 0, len(t.fields))
+		for _, f := range t.fields {
+			if f.typ == nil {
+				alts = append(alts, dhallLabel(f.label))
+			} else {
+				alts = append(alts, fmt.Sprintf("%s : %s", dhallLabel(f.label), e.typeText(f.typ, false)))
+			}
+		}
+		return "< " + strings.Join(alts, " | ") + " >"
+	}
+
+	if arg {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// appendPath returns a new path with label appended. Paths consist of the component, kind and name of a resource
+// followed by field labels and the keys of list elements.
+func appendPath(path []string, label string) []string {
+	p := make([]string, len(path), len(path)+1)
+	copy(p, path)
+	return append(p, label)
+}
+
+func formatPath(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// elementKey returns the path label of a list element, its name if it has one or else its index.
+func elementKey(item interface{}, index int) string {
+	if m, ok := item.(map[string]interface{}); ok {
+		if name, ok := m["name"].(string); ok {
+			return name
+		}
+	}
+	return strconv.Itoa(index)
+}
+
+// emitResources renders every resource of the set. It returns the Dhall fragments of the resources and the emitter,
+// which knows the let bindings and params the fragments rely on.
+func emitResources(loader *typeLoader, rs *comkir.ResourceSet, completion bool, selectors []selector,
+	images *imageExtractor) (map[*comkir.Resource]string, *emitter, error) {
+	e := &emitter{types: loader, completion: completion, selectors: selectors, params: newParamNode(), images: images}
+	if images != nil {
+		images.collect(rs)
+	}
+	fragments := make(map[*comkir.Resource]string)
+
+	for _, resources := range rs.Components {
+		for _, r := range resources {
+			t, err := loader.named(r.Kind)
+			if err != nil {
+				return nil, nil, fmt.Errorf("resource %s: %w", r.Source, err)
+			}
+			x, err := e.emit(r.Contents, t, []string{r.Component, r.Kind, r.Name})
+			if err != nil {
+				return nil, nil, fmt.Errorf("resource %s: %w", r.Source, err)
+			}
+			fragments[r] = x.text
+		}
+	}
+
+	return fragments, e, nil
+}
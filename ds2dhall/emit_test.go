@@ -0,0 +1,306 @@
+package ds2dhall
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir"
+	"gopkg.in/yaml.v3"
+)
+
+var testTypeFiles = map[string]string{
+	"k8s/types/io.k8s.api.core.v1.Service.dhall": `
+{ apiVersion : Text
+, kind : Text
+, metadata : ./io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta.dhall
+, spec : Optional ./io.k8s.api.core.v1.ServiceSpec.dhall
+}`,
+	"k8s/defaults/io.k8s.api.core.v1.Service.dhall": `
+{ apiVersion = "v1"
+, kind = "Service"
+, spec = None ./../types/io.k8s.api.core.v1.ServiceSpec.dhall
+}`,
+	"k8s/types/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta.dhall": `
+{ annotations : Optional (List { mapKey : Text, mapValue : Text })
+, labels : Optional (List { mapKey : Text, mapValue : Text })
+, name : Optional Text
+, namespace : Optional Text
+}`,
+	"k8s/types/io.k8s.api.core.v1.ServiceSpec.dhall": `
+{ clusterIP : Optional Text
+, ports : Optional (List ./io.k8s.api.core.v1.ServicePort.dhall)
+, selector : Optional (List { mapKey : Text, mapValue : Text })
+, type : Optional Text
+, externalIPs : Optional (List Text)
+}`,
+	"k8s/types/io.k8s.api.core.v1.ServicePort.dhall": `
+{ name : Optional Text
+, port : Natural
+, targetPort : Optional ./io.k8s.apimachinery.pkg.util.intstr.IntOrString.dhall
+, ` + "`protocol`" + ` : Optional Text
+}`,
+	"k8s/defaults/io.k8s.api.core.v1.ServicePort.dhall":               `{ port = 80, ` + "`protocol`" + ` = Some "TCP" }`,
+	"k8s/types/io.k8s.apimachinery.pkg.util.intstr.IntOrString.dhall": `< Int : Natural | String : Text >`,
+	"k8s/types/io.k8s.api.core.v1.Pod.dhall": `
+{ apiVersion : Text
+, kind : Text
+, metadata : ./io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta.dhall
+, spec : Optional ./io.k8s.api.core.v1.PodSpec.dhall
+}`,
+	"k8s/defaults/io.k8s.api.core.v1.Pod.dhall": `{ apiVersion = "v1", kind = "Pod" }`,
+	"k8s/types/io.k8s.api.core.v1.PodSpec.dhall": `
+{ containers : List ./io.k8s.api.core.v1.Container.dhall
+, initContainers : Optional (List ./io.k8s.api.core.v1.Container.dhall)
+}`,
+	"k8s/types/io.k8s.api.core.v1.Container.dhall": `{ image : Optional Text, name : Text }`,
+}
+
+// testDefaultValues are the defaults of testTypeFiles as evaluated by dhall-to-json.
+var testDefaultValues = map[string]string{
+	"k8s/defaults/io.k8s.api.core.v1.Service.dhall":     `{"apiVersion": "v1", "kind": "Service", "spec": null}`,
+	"k8s/defaults/io.k8s.api.core.v1.ServicePort.dhall": `{"port": 80, "protocol": "TCP"}`,
+	"k8s/defaults/io.k8s.api.core.v1.Pod.dhall":         `{"apiVersion": "v1", "kind": "Pod"}`,
+}
+
+func newTestTypeLoader() *typeLoader {
+	l := newTypeLoader("k8s", map[string]string{
+		"Service":     "./types/io.k8s.api.core.v1.Service.dhall",
+		"ServiceSpec": "./types/io.k8s.api.core.v1.ServiceSpec.dhall",
+		"ServicePort": "./types/io.k8s.api.core.v1.ServicePort.dhall",
+		"ObjectMeta":  "./types/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta.dhall",
+		"IntOrString": "./types/io.k8s.apimachinery.pkg.util.intstr.IntOrString.dhall",
+		"Pod":         "./types/io.k8s.api.core.v1.Pod.dhall",
+		"PodSpec":     "./types/io.k8s.api.core.v1.PodSpec.dhall",
+		"Container":   "./types/io.k8s.api.core.v1.Container.dhall",
+	})
+	l.fetch = func(url string) ([]byte, error) {
+		contents, ok := testTypeFiles[url]
+		if !ok {
+			return nil, fmt.Errorf("not found: %s", url)
+		}
+		return []byte(contents), nil
+	}
+	l.evaluate = func(url string) ([]byte, error) {
+		contents, ok := testDefaultValues[url]
+		if !ok {
+			return nil, fmt.Errorf("not found: %s", url)
+		}
+		return []byte(contents), nil
+	}
+	return l
+}
+
+func TestEmitResources(t *testing.T) {
+	manifest := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: frontend
+  labels:
+    app: frontend
+    app.kubernetes.io/component: frontend
+spec:
+  externalIPs: []
+  ports:
+  - name: http
+    port: 30080
+    targetPort: http
+  - port: 6060
+    targetPort: 6060
+    protocol: TCP
+  - port: 80
+  selector:
+    app: frontend
+  type: ClusterIP
+`
+	r := &comkir.Resource{Component: "frontend", Kind: "Service", Name: "frontend"}
+	err := yaml.Unmarshal([]byte(manifest), &r.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{"frontend": {r}}}
+
+	fragments, e, err := emitResources(newTestTypeLoader(), rs, true, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := e.header()
+	expectedHeader := "let schemas = k8s/schemas.dhall\n\nlet types = k8s/types.dhall\n\nin  "
+	if header != expectedHeader {
+		t.Errorf("expected header %q, got %q", expectedHeader, header)
+	}
+
+	expected := "schemas.Service::{ " +
+		"metadata = schemas.ObjectMeta::{ labels = Some (toMap { app = \"frontend\", `app.kubernetes.io/component` = \"frontend\" }), name = Some \"frontend\" }, " +
+		"spec = Some schemas.ServiceSpec::{ " +
+		"ports = Some [ schemas.ServicePort::{ name = Some \"http\", port = 30080, targetPort = Some (types.IntOrString.String \"http\") }, " +
+		"schemas.ServicePort::{ port = 6060, targetPort = Some (types.IntOrString.Int 6060) }, schemas.ServicePort::{=} ], " +
+		"selector = Some (toMap { app = \"frontend\" }), type = Some \"ClusterIP\", externalIPs = Some ([] : List Text) } }"
+	if fragments[r] != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, fragments[r])
+	}
+}
+
+func TestEmitRecordsAndParams(t *testing.T) {
+	manifest := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: frontend
+spec:
+  ports:
+  - name: http
+    port: 30080
+  type: ClusterIP
+`
+	r := &comkir.Resource{Component: "frontend", Kind: "Service", Name: "frontend"}
+	err := yaml.Unmarshal([]byte(manifest), &r.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{"frontend": {r}}}
+
+	selectors, err := parseSelectors([]string{"*.Service.*.spec.ports.*.port", "*.*.*.spec.type", "*.*.*.spec.clusterIP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragments, e, err := emitResources(newTestTypeLoader(), rs, false, selectors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{ apiVersion = "v1", kind = "Service", ` +
+		`metadata = { annotations = None (List { mapKey : Text, mapValue : Text }), labels = None (List { mapKey : Text, mapValue : Text }), name = Some "frontend", namespace = None Text }, ` +
+		`spec = Some { clusterIP = None Text, ports = Some [ { name = Some "http", port = params.frontend.Service.frontend.spec.ports.http.port, targetPort = None types.IntOrString, protocol = None Text } ], ` +
+		`selector = None (List { mapKey : Text, mapValue : Text }), type = params.frontend.Service.frontend.spec.type, externalIPs = None (List Text) } }`
+	if fragments[r] != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, fragments[r])
+	}
+
+	expectedParams := "let types = k8s/types.dhall\n\nin  { Type = { frontend : { Service : { frontend : { spec : { ports : { http : { port : Natural } }, type : Optional Text } } } } }, " +
+		"default = { frontend = { Service = { frontend = { spec = { ports = { http = { port = 30080 } }, type = Some \"ClusterIP\" } } } } } }\n"
+	if e.paramsRecord() != expectedParams {
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedParams, e.paramsRecord())
+	}
+
+	if selectors[2].matched {
+		t.Errorf("selector for the missing clusterIP should not match")
+	}
+}
+
+func TestEmitImages(t *testing.T) {
+	manifest := `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: frontend
+spec:
+  initContainers:
+  - name: migrator
+    image: index.docker.io/sourcegraph/migrator:3.20.0@sha256:0e23b0cc8bfbe4e7b1d1a8f3d8e3a0a4d3ec9a3c9c1f3cfd0d4f1c3a7c8c6f3b
+  containers:
+  - name: frontend
+    image: sourcegraph/frontend:3.20.0
+  - name: jaeger
+    image: sourcegraph/frontend:3.21.0
+`
+	r := &comkir.Resource{Component: "frontend", Kind: "Pod", Name: "frontend"}
+	err := yaml.Unmarshal([]byte(manifest), &r.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{"frontend": {r}}}
+
+	images, err := newImageExtractor("/deploy/record.dhall", "/deploy/images/images.dhall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments, _, err := emitResources(newTestTypeLoader(), rs, true, nil, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"showImage images.`sourcegraph/frontend:3.20.0`", "showImage images.`sourcegraph/frontend:3.21.0`"} {
+		if !strings.Contains(fragments[r], ref) {
+			t.Errorf("expected a distinct entry for each frontend tag, %s is missing from:\n%s", ref, fragments[r])
+		}
+	}
+	if len(images.imgRefs) != 3 {
+		t.Errorf("unexpected images %v", images.imgRefs)
+	}
+
+	r.Contents["spec"].(map[string]interface{})["containers"] = r.Contents["spec"].(map[string]interface{})["containers"].([]interface{})[:1]
+	images, err = newImageExtractor("/deploy/record.dhall", "/deploy/images/images.dhall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments, e, err := emitResources(newTestTypeLoader(), rs, true, nil, images)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `schemas.Pod::{ metadata = schemas.ObjectMeta::{ name = Some "frontend" }, spec = Some schemas.PodSpec::{ ` +
+		"containers = [ schemas.Container::{ image = Some (showImage images.sourcegraph/frontend), name = \"frontend\" } ], " +
+		"initContainers = Some [ schemas.Container::{ image = Some (showImage images.sourcegraph/migrator), name = \"migrator\" } ] } }"
+	if fragments[r] != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, fragments[r])
+	}
+
+	if !strings.Contains(e.header(), "let images = ./images/images.dhall\n") {
+		t.Errorf("header does not import the images record:\n%s", e.header())
+	}
+	if len(images.imgRefs) != 2 || images.imgRefs[1].Registry != "index.docker.io" || images.imgRefs[1].Version != "3.20.0" {
+		t.Errorf("unexpected images %v", images.imgRefs)
+	}
+}
+
+func TestEmitMissingRequiredField(t *testing.T) {
+	r := &comkir.Resource{
+		Kind:     "Service",
+		Source:   "service.yaml",
+		Contents: map[string]interface{}{"spec": map[string]interface{}{"ports": []interface{}{map[string]interface{}{}}}},
+	}
+	rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{"frontend": {r}}}
+
+	_, _, err := emitResources(newTestTypeLoader(), rs, true, nil, nil)
+	if err == nil {
+		t.Errorf("expected an error for the missing metadata field")
+	}
+}
+
+func TestEmitUnionRestoresState(t *testing.T) {
+	// the first alternative extracts k before it fails on the missing m
+	union, err := parseDhallType("union.dhall", []byte(
+		"< A : { k : Natural, l : List ./io.k8s.api.core.v1.Container.dhall, m : Natural } | B : { l : List Text } >"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectors, err := parseSelectors([]string{"c.Kind.n.k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &emitter{types: newTestTypeLoader(), selectors: selectors, params: newParamNode()}
+
+	v := map[string]interface{}{"k": 1, "l": []interface{}{}}
+	x, err := e.emit(v, union, []string{"c", "Kind", "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "< A : { k : Natural, l : List types.Container, m : Natural } | B : { l : List Text } >.B { l = [] : List Text }"
+	if x.text != expected {
+		t.Errorf("expected %s, got %s", expected, x.text)
+	}
+	if !e.params.empty() || e.selectors[0].matched {
+		t.Errorf("the failed alternative left params %s behind", e.params.render(":"))
+	}
+}
+
+func TestDhallText(t *testing.T) {
+	got := dhallText("a \"quoted\" ${value}\n")
+	expected := `"a \"quoted\" \${value}\n"`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
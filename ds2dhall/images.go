@@ -0,0 +1,175 @@
+package ds2dhall
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/dockerimg"
+	"github.com/inconshreveable/log15"
+)
+
+// showImage renders an entry of the dockerimg images record as an image reference.
+const showImage = `let showImage =
+      λ(image : { registry : Optional Text, name : Text, tag : Optional Text, digest : Optional Text }) →
+            merge { None = "", Some = λ(registry : Text) → registry ++ "/" } image.registry
+        ++  image.name
+        ++  merge { None = "", Some = λ(tag : Text) → ":" ++ tag } image.tag
+        ++  merge { None = "", Some = λ(digest : Text) → "@sha256:" ++ digest } image.digest
+
+`
+
+// imageExtractor collects the container images of the emitted resources into a dockerimg images record.
+type imageExtractor struct {
+	// importPath is the Dhall import of the images record relative to the emitted record
+	importPath string
+
+	imgRefs []*dockerimg.ImageReference
+	// byImage are the entries of the record by the image as written in the manifests
+	byImage map[string]*dockerimg.ImageReference
+}
+
+func newImageExtractor(recordFile string, imagesFile string) (*imageExtractor, error) {
+	pas, err := makeAbs([]string{recordFile, imagesFile})
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(filepath.Dir(pas[0]), pas[1])
+	if err != nil {
+		return nil, err
+	}
+	return &imageExtractor{
+		importPath: dhallImport(filepath.ToSlash(rel)),
+		byImage:    make(map[string]*dockerimg.ImageReference),
+	}, nil
+}
+
+// isImagePath reports whether path points to the image of a container or init container.
+func isImagePath(path []string) bool {
+	n := len(path)
+	return n >= 3 && path[n-1] == "image" && (path[n-3] == "containers" || path[n-3] == "initContainers")
+}
+
+// walkImages calls fn with the container images below v, at the paths the emitter visits them at.
+func walkImages(v interface{}, path []string, fn func(image string)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range v {
+			walkImages(fv, appendPath(path, k), fn)
+		}
+	case []interface{}:
+		for i, item := range v {
+			walkImages(item, appendPath(path, elementKey(item, i)), fn)
+		}
+	case string:
+		if isImagePath(path) {
+			fn(v)
+		}
+	}
+}
+
+// sameVariant reports whether the references name the same registry, tag and digest of an image.
+func sameVariant(a, b *dockerimg.ImageReference) bool {
+	return a.Registry == b.Registry && a.Version == b.Version && a.Sha256 == b.Sha256
+}
+
+// collect adds the container images of the resources to the record before they are emitted. An image used with
+// different registries, tags or digests gets an entry per variant, keyed like sourcegraph/frontend:3.20.0.
+func (x *imageExtractor) collect(rs *comkir.ResourceSet) {
+	var keys []string
+	variants := make(map[string][]*dockerimg.ImageReference)
+	// variantOf is the index of the variant of every image in the variants of its key
+	variantOf := make(map[string]int)
+
+	for _, resources := range rs.Components {
+		for _, r := range resources {
+			walkImages(r.Contents, []string{r.Component, r.Kind, r.Name}, func(image string) {
+				if _, ok := variantOf[image]; ok {
+					return
+				}
+				imgRef, err := dockerimg.ParseImageReference(image)
+				if err != nil {
+					// the emitter keeps the literal and reports it
+					return
+				}
+				vs, ok := variants[imgRef.Key]
+				if !ok {
+					keys = append(keys, imgRef.Key)
+				}
+				for i, v := range vs {
+					if sameVariant(v, imgRef) {
+						variantOf[image] = i
+						return
+					}
+				}
+				variantOf[image] = len(vs)
+				variants[imgRef.Key] = append(vs, imgRef)
+			})
+		}
+	}
+
+	sort.Strings(keys)
+	entries := make(map[string][]*dockerimg.ImageReference)
+	for _, key := range keys {
+		vs := variants[key]
+		if len(vs) > 1 {
+			vs = dockerimg.DistinctImageReferences(vs)
+			distinct := make([]string, 0, len(vs))
+			for _, v := range vs {
+				distinct = append(distinct, v.Key)
+			}
+			log15.Warn("image is used with different registries, tags or digests, keeping every variant", "image", key,
+				"keys", strings.Join(distinct, " "))
+		}
+		entries[key] = vs
+		x.imgRefs = append(x.imgRefs, vs...)
+	}
+
+	for image, i := range variantOf {
+		imgRef, _ := dockerimg.ParseImageReference(image)
+		x.byImage[image] = entries[imgRef.Key][i]
+	}
+}
+
+// extractImage adds the image to the images record and returns a reference to it. ok is false if the value is kept
+// as a literal because it is not an image reference that can be parsed.
+func (e *emitter) extractImage(v interface{}, t *dhallType, path []string) (x dhallExpr, ok bool, err error) {
+	image, ok := v.(string)
+	if !ok {
+		return dhallExpr{}, false, nil
+	}
+
+	imgRef, err := dockerimg.ParseImageReference(image)
+	if err != nil {
+		log15.Warn("keeping image literal that cannot be parsed", "path", formatPath(path), "image", image, "err", err)
+		return dhallExpr{}, false, nil
+	}
+
+	imgRef, ok = e.images.byImage[image]
+	if !ok {
+		return dhallExpr{}, false, fmt.Errorf("%s: image %s was not collected", formatPath(path), image)
+	}
+
+	ref := fmt.Sprintf("showImage images.%s", dhallLabel(imgRef.Key))
+	if t.kind == tOptional {
+		return dhallExpr{text: fmt.Sprintf("Some (%s)", ref)}, true, nil
+	}
+	return dhallExpr{text: ref}, true, nil
+}
+
+// imagesRecord returns the contents of the images file.
+func (x *imageExtractor) imagesRecord() (string, error) {
+	sort.Slice(x.imgRefs, func(i, j int) bool {
+		return x.imgRefs[i].Key < x.imgRefs[j].Key
+	})
+
+	var b bytes.Buffer
+	err := dockerimg.WriteImagesRecord(&b, x.imgRefs)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
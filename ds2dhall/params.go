@@ -0,0 +1,155 @@
+package ds2dhall
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+)
+
+// selector matches value paths like *.Deployment.*.spec.replicas. The first three segments match the component,
+// kind and name of a resource, the rest match field labels or the keys of list elements (their name, or their index
+// if they have none). A * segment matches anything.
+type selector struct {
+	raw      string
+	segments []string
+	matched  bool
+}
+
+func parseSelectors(raw []string) ([]selector, error) {
+	selectors := make([]selector, 0, len(raw))
+	for _, r := range raw {
+		segments := strings.Split(r, ".")
+		if len(segments) < 3 {
+			return nil, fmt.Errorf("selector %q must at least select component, kind and name", r)
+		}
+		for _, s := range segments {
+			if s == "" {
+				return nil, fmt.Errorf("selector %q has an empty segment", r)
+			}
+		}
+		selectors = append(selectors, selector{raw: r, segments: segments})
+	}
+	return selectors, nil
+}
+
+func (s *selector) matches(path []string) bool {
+	if len(path) != len(s.segments) {
+		return false
+	}
+	for i, segment := range s.segments {
+		if segment != "*" && segment != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// paramNode is a node of the params record. Inner nodes are records, leaves hold the type and the current value of
+// an extracted field.
+type paramNode struct {
+	labels   []string
+	children map[string]*paramNode
+
+	typ   string
+	value string
+}
+
+func newParamNode() *paramNode {
+	return &paramNode{children: make(map[string]*paramNode)}
+}
+
+func (n *paramNode) add(path []string, typ string, value string) {
+	for _, label := range path {
+		child, ok := n.children[label]
+		if !ok {
+			child = newParamNode()
+			n.children[label] = child
+			n.labels = append(n.labels, label)
+		}
+		n = child
+	}
+	n.typ = typ
+	n.value = value
+}
+
+func (n *paramNode) clone() *paramNode {
+	c := &paramNode{labels: append([]string(nil), n.labels...), children: make(map[string]*paramNode),
+		typ: n.typ, value: n.value}
+	for label, child := range n.children {
+		c.children[label] = child.clone()
+	}
+	return c
+}
+
+func (n *paramNode) empty() bool {
+	return len(n.labels) == 0
+}
+
+// render renders the node as a record type (with separator ":") or a record value (with separator "=").
+func (n *paramNode) render(separator string) string {
+	if n.empty() {
+		switch {
+		case n.typ == "" && separator == ":":
+			return "{}"
+		case n.typ == "":
+			return "{=}"
+		case separator == ":":
+			return n.typ
+		}
+		return n.value
+	}
+
+	fields := make([]string, 0, len(n.labels))
+	for _, label := range n.labels {
+		fields = append(fields, fmt.Sprintf("%s %s %s", dhallLabel(label), separator, n.children[label].render(separator)))
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+func (e *emitter) extracted(path []string) bool {
+	for i := range e.selectors {
+		if e.selectors[i].matches(path) {
+			e.selectors[i].matched = true
+			return true
+		}
+	}
+	return false
+}
+
+// extract records the value as a param and returns a reference to it. Selectors matching inside the extracted value
+// are ignored.
+func (e *emitter) extract(v interface{}, t *dhallType, path []string) (dhallExpr, error) {
+	e.extracting = true
+	x, err := e.emitValue(v, t, path)
+	e.extracting = false
+	if err != nil {
+		return dhallExpr{}, err
+	}
+	e.params.add(path, e.typeText(t, false), x.text)
+
+	ref := make([]string, 0, len(path)+1)
+	ref = append(ref, "params")
+	for _, label := range path {
+		ref = append(ref, dhallLabel(label))
+	}
+	return dhallExpr{text: strings.Join(ref, "."), atomic: true}, nil
+}
+
+func (e *emitter) warnUnmatchedSelectors() {
+	for _, s := range e.selectors {
+		if !s.matched {
+			log15.Warn("selector did not match any value", "selector", s.raw)
+		}
+	}
+}
+
+// paramsRecord returns the params file, a record with the Type of the params and their current values as default.
+func (e *emitter) paramsRecord() string {
+	return fmt.Sprintf("%s{ Type = %s, default = %s }\n", e.header(), e.params.render(":"), e.params.render("="))
+}
+
+// functionHeader returns the header of a record that is a function of the params.
+func (e *emitter) functionHeader() string {
+	return fmt.Sprintf("%slet Params = %s\n\nin  λ(params : Params) → ", e.bindings(), e.params.render(":"))
+}
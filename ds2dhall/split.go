@@ -0,0 +1,163 @@
+package ds2dhall
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"ds-to-dhall/comkir"
+)
+
+// isDhallPathChar reports whether c may appear in an unquoted Dhall path component.
+func isDhallPathChar(c rune) bool {
+	switch {
+	case c == '!', c >= '$' && c <= '\'', c == '*', c == '+', c == '-', c == '.', c >= '0' && c <= ';', c == '=',
+		c >= '@' && c <= 'Z', c >= '^' && c <= 'z', c == '|', c == '~':
+		return true
+	}
+	return false
+}
+
+// dhallPathComponent returns s as a component of a Dhall path import, quoting it when necessary.
+func dhallPathComponent(s string) string {
+	for _, c := range s {
+		if !isDhallPathChar(c) {
+			return fmt.Sprintf("%q", s)
+		}
+	}
+	return s
+}
+
+// dhallImport returns the relative Dhall import for a slash separated relative path.
+func dhallImport(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = dhallPathComponent(part)
+	}
+	if parts[0] == ".." {
+		return strings.Join(parts, "/")
+	}
+	return "./" + strings.Join(parts, "/")
+}
+
+// writeGeneratedDhall writes contents to file, formats it and prepends the generated comment.
+func writeGeneratedDhall(file string, contents string) error {
+	err := os.MkdirAll(filepath.Dir(file), 0777)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(file, []byte(contents), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = dhallFormat(file)
+	if err != nil {
+		return fmt.Errorf("failed to format dhall file %s: %w", file, err)
+	}
+
+	return prependLine(file, GeneratedComment)
+}
+
+// checkComponent returns an error if the files of the component cannot be written below the package directory, or
+// would overwrite the package.dhall at its root.
+func checkComponent(component string, perResource bool) error {
+	if component == "" || filepath.IsAbs(component) {
+		return fmt.Errorf("component %q is not a relative path", component)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(component), "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("component %q is not a path below the package directory", component)
+		}
+	}
+	if component == "package" && !perResource {
+		return fmt.Errorf("component %q would overwrite the root package.dhall, use --split-resources or rename it", component)
+	}
+	return nil
+}
+
+// composePackage returns the contents of the files of a Dhall package for the resource set, keyed by their slash
+// separated path relative to the package directory. Every component is written to <component>.dhall, or with
+// perResource to <component>/package.dhall importing <component>/<kind>.<name>.dhall files. The package.dhall at
+// the root assembles the components and is annotated with dhallType so that it type-checks like the single file
+// record. header is prepended to every file that contains fragments. Components that are not relative paths below the
+// package directory are rejected.
+func composePackage(rs *comkir.ResourceSet, fragments map[*comkir.Resource]string, header string, dhallType string,
+	perResource bool) (map[string]string, error) {
+	files := make(map[string]string)
+
+	components := make([]string, 0, len(rs.Components))
+	for component := range rs.Components {
+		err := checkComponent(component, perResource)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	var pkg strings.Builder
+	pkg.WriteString("{ ")
+	for i, component := range components {
+		componentFile := component + ".dhall"
+
+		var b strings.Builder
+		if perResource {
+			componentFile = component + "/package.dhall"
+
+			composeComponent(&b, rs.Components[component], func(r *comkir.Resource) string {
+				resourceFile := fmt.Sprintf("%s.%s.dhall", r.Kind, r.Name)
+				files[component+"/"+resourceFile] = header + fragments[r]
+				return dhallImport(resourceFile)
+			})
+		} else {
+			b.WriteString(header)
+			composeComponent(&b, rs.Components[component], func(r *comkir.Resource) string {
+				return strings.TrimSpace(fragments[r])
+			})
+		}
+		b.WriteString("\n")
+		files[componentFile] = b.String()
+
+		if i > 0 {
+			pkg.WriteString(", ")
+		}
+		fmt.Fprintf(&pkg, "%s = %s", dhallLabel(component), dhallImport(componentFile))
+	}
+	pkg.WriteString(" }")
+
+	if dhallType != "" {
+		fmt.Fprintf(&pkg, " : %s", dhallType)
+	}
+	pkg.WriteString("\n")
+	files["package.dhall"] = pkg.String()
+
+	return files, nil
+}
+
+// writePackage writes the Dhall package for the resource set into dir.
+func writePackage(dir string, rs *comkir.ResourceSet, fragments map[*comkir.Resource]string, header string,
+	dhallType string, perResource bool) error {
+	files, err := composePackage(rs, fragments, header, dhallType, perResource)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		err := writeGeneratedDhall(filepath.Join(dir, filepath.FromSlash(path)), files[path])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
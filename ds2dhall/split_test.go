@@ -0,0 +1,80 @@
+package ds2dhall
+
+import (
+	"reflect"
+	"testing"
+
+	"ds-to-dhall/comkir"
+)
+
+func TestComposePackage(t *testing.T) {
+	svc := &comkir.Resource{Component: "gitserver", Kind: "Service", Name: "gitserver"}
+	sts := &comkir.Resource{Component: "gitserver", Kind: "StatefulSet", Name: "gitserver"}
+	cm := &comkir.Resource{Component: "base/frontend", Kind: "ConfigMap", Name: "frontend"}
+
+	rs := &comkir.ResourceSet{
+		Components: map[string][]*comkir.Resource{
+			"gitserver":     {sts, svc},
+			"base/frontend": {cm},
+		},
+	}
+	fragments := map[*comkir.Resource]string{
+		svc: "{ a = 1 }\n",
+		sts: "{ a = 2 }\n",
+		cm:  "{ a = 3 }\n",
+	}
+
+	expected := map[string]string{
+		"base/frontend.dhall": "{ ConfigMap = { frontend = { a = 3 } } }\n",
+		"gitserver.dhall":     "{ Service = { gitserver = { a = 1 } }, StatefulSet = { gitserver = { a = 2 } } }\n",
+		"package.dhall":       "{ base/frontend = ./base/frontend.dhall, gitserver = ./gitserver.dhall } : T\n",
+	}
+	got, err := composePackage(rs, fragments, "", "T", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	expected = map[string]string{
+		"base/frontend/ConfigMap.frontend.dhall": "{ a = 3 }\n",
+		"base/frontend/package.dhall":            "{ ConfigMap = { frontend = ./ConfigMap.frontend.dhall } }\n",
+		"gitserver/Service.gitserver.dhall":      "{ a = 1 }\n",
+		"gitserver/StatefulSet.gitserver.dhall":  "{ a = 2 }\n",
+		"gitserver/package.dhall": "{ Service = { gitserver = ./Service.gitserver.dhall }, " +
+			"StatefulSet = { gitserver = ./StatefulSet.gitserver.dhall } }\n",
+		"package.dhall": "{ base/frontend = ./base/frontend/package.dhall, gitserver = ./gitserver/package.dhall } : T\n",
+	}
+	got, err = composePackage(rs, fragments, "", "T", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
+
+func TestComposePackageRejectsComponents(t *testing.T) {
+	for _, tc := range []struct {
+		component   string
+		perResource bool
+	}{
+		{"package", false},
+		{"../frontend", false},
+		{"base/../../frontend", true},
+		{"/", true},
+		{"/etc", false},
+	} {
+		rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{tc.component: nil}}
+		if _, err := composePackage(rs, nil, "", "", tc.perResource); err == nil {
+			t.Errorf("expected an error for component %q", tc.component)
+		}
+	}
+
+	rs := &comkir.ResourceSet{Components: map[string][]*comkir.Resource{"package": nil}}
+	files, err := composePackage(rs, nil, "", "", true)
+	if err != nil || files["package.dhall"] != "{ package = ./package/package.dhall }\n" {
+		t.Errorf("expected a package component in its own directory, got %v %v", files, err)
+	}
+}
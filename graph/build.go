@@ -0,0 +1,160 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/refs"
+)
+
+// Node is a resource of the graph.
+type Node struct {
+	// ID is component.Kind.name, which is unique within a resource set
+	ID        string `json:"id"`
+	Component string `json:"component"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+}
+
+// Edge points from a resource to a resource it references or selects. Paths are the fields holding the references.
+type Edge struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Paths []string `json:"paths"`
+}
+
+// Graph is the dependency graph of a resource set.
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+}
+
+func nodeID(res *comkir.Resource) string {
+	return res.Component + "." + res.Kind + "." + res.Name
+}
+
+// Build returns the graph of the resources of the set with edges from services and pod disruption budgets to the
+// workloads they select, from workloads to the config maps, secrets, claims and service accounts they use, from role
+// bindings to their roles and service accounts, and from ingresses to their services.
+func Build(rs *comkir.ResourceSet) (*Graph, error) {
+	g := &Graph{Nodes: []*Node{}, Edges: []*Edge{}}
+	for component, resources := range rs.Components {
+		for _, res := range resources {
+			g.Nodes = append(g.Nodes, &Node{ID: nodeID(res), Component: component, Kind: res.Kind, Name: res.Name})
+		}
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+
+	// a workload can use the same secret in several places, which is a single edge
+	links, err := refs.Links(rs)
+	if err != nil {
+		return nil, err
+	}
+	edges := make(map[[2]string]*Edge)
+	for _, link := range links {
+		key := [2]string{nodeID(link.From), nodeID(link.To)}
+		e, ok := edges[key]
+		if !ok {
+			e = &Edge{From: key[0], To: key[1]}
+			edges[key] = e
+			g.Edges = append(g.Edges, e)
+		}
+		e.Paths = append(e.Paths, link.Path)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return g, nil
+}
+
+// components returns the nodes of the graph by component, and the components in order.
+func (g *Graph) components() ([]string, map[string][]*Node) {
+	var components []string
+	nodes := make(map[string][]*Node)
+	for _, n := range g.Nodes {
+		if _, ok := nodes[n.Component]; !ok {
+			components = append(components, n.Component)
+		}
+		nodes[n.Component] = append(nodes[n.Component], n)
+	}
+	sort.Strings(components)
+	return components, nodes
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language with a cluster per component.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "digraph resources {")
+	fmt.Fprintln(b, "  rankdir=LR;")
+	fmt.Fprintln(b, "  node [shape=box];")
+	components, nodes := g.components()
+	for i, component := range components {
+		fmt.Fprintf(b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(b, "    label=%s;\n", strconv.Quote(component))
+		for _, n := range nodes[component] {
+			fmt.Fprintf(b, "    %s [label=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Kind+"\n"+n.Name))
+		}
+		fmt.Fprintln(b, "  }")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+	fmt.Fprintln(b, "}")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidText escapes the quotes of a Mermaid label.
+func mermaidText(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart with a subgraph per component. Mermaid ids cannot contain
+// dots, so nodes are numbered in order.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "graph LR")
+	ids := make(map[string]string)
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+	}
+	components, nodes := g.components()
+	for i, component := range components {
+		fmt.Fprintf(b, "  subgraph c%d [\"%s\"]\n", i, mermaidText(component))
+		for _, n := range nodes[component] {
+			fmt.Fprintf(b, "    %s[\"%s %s\"]\n", ids[n.ID], mermaidText(n.Kind), mermaidText(n.Name))
+		}
+		fmt.Fprintln(b, "  end")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "  %s --> %s\n", ids[e.From], ids[e.To])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Write writes the graph in format, one of dot, mermaid or json.
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "mermaid":
+		return g.WriteMermaid(w)
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(g)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
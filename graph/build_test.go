@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir/comkirtest"
+)
+
+func TestBuild(t *testing.T) {
+	rs := comkirtest.ResourceSet(t, map[string]string{
+		"frontend/Deployment/frontend": `
+spec:
+  template:
+    metadata:
+      labels:
+        app: sourcegraph-frontend
+    spec:
+      serviceAccountName: sourcegraph-frontend
+      containers:
+      - name: frontend
+        env:
+        - name: PGPASSWORD
+          valueFrom:
+            secretKeyRef: {name: pgsql-auth, key: password}
+      volumes:
+      - name: auth
+        secret:
+          secretName: pgsql-auth
+`,
+		"frontend/Service/sourcegraph-frontend": `
+spec:
+  selector:
+    app: sourcegraph-frontend
+`,
+		"frontend/ServiceAccount/sourcegraph-frontend": "{}",
+		"frontend/RoleBinding/sourcegraph-frontend": `
+roleRef: {kind: Role, name: sourcegraph-frontend}
+subjects:
+- kind: ServiceAccount
+  name: sourcegraph-frontend
+`,
+		"frontend/Role/sourcegraph-frontend": "{}",
+		"pgsql/Secret/pgsql-auth":            "{}",
+	})
+
+	g, err := Build(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.From+" -> "+e.To+" "+strings.Join(e.Paths, ","))
+	}
+	expected := []string{
+		"frontend.Deployment.frontend -> frontend.ServiceAccount.sourcegraph-frontend spec.template.spec.serviceAccountName",
+		"frontend.Deployment.frontend -> pgsql.Secret.pgsql-auth spec.template.spec.volumes[0].secret,spec.template.spec.containers[0].env[0].valueFrom.secretKeyRef",
+		"frontend.RoleBinding.sourcegraph-frontend -> frontend.Role.sourcegraph-frontend roleRef",
+		"frontend.RoleBinding.sourcegraph-frontend -> frontend.ServiceAccount.sourcegraph-frontend subjects[0]",
+		"frontend.Service.sourcegraph-frontend -> frontend.Deployment.frontend spec.selector",
+	}
+	if strings.Join(edges, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(edges, "\n"))
+	}
+
+	var b bytes.Buffer
+	err = g.Write(&b, "dot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"  subgraph cluster_1 {\n    label=\"pgsql\";\n    \"pgsql.Secret.pgsql-auth\" [label=\"Secret\\npgsql-auth\"];\n  }\n",
+		"  \"frontend.Service.sourcegraph-frontend\" -> \"frontend.Deployment.frontend\";\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected DOT to contain %q, got\n%s", line, b.String())
+		}
+	}
+
+	b.Reset()
+	err = g.Write(&b, "mermaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"  subgraph c1 [\"pgsql\"]\n    n5[\"Secret pgsql-auth\"]\n  end\n", "  n3 --> n0\n"} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected Mermaid to contain %q, got\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ds-to-dhall/console"
+	"ds-to-dhall/ds2dhall"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const ShortDescription = "exports the dependencies between resources as a DOT, Mermaid or JSON graph"
+
+var (
+	format      string
+	ignoreFiles []string
+	timeout     time.Duration
+
+	printHelp bool
+
+	flagSet *flag.FlagSet
+)
+
+func logFatal(message string, ctx ...interface{}) {
+	log15.Error(message, ctx...)
+	os.Exit(1)
+}
+
+func usageArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) a COMKIR Dhall record, or Kubernetes YAML files and directories containing them")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+// Flags returns the flag set of graph, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
+	flagSet = flag.NewFlagSet("graph", flag.ExitOnError)
+
+	flagSet.StringVar(&format, "format", "dot", "output format: dot, mermaid or json")
+	flagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
+	flagSet.DurationVar(&timeout, "timeout", 5*time.Minute, "length of time to run dhall-to-yaml before timing out")
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	flagSet.Usage = func() {
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "graph %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall graph: <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+	}
+	return flagSet
+}
+
+func Main(args []string, mainCtx context.Context) {
+	_ = Flags().Parse(args)
+
+	if printHelp {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(flagSet.Args()) == 0 || (format != "dot" && format != "mermaid" && format != "json") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(mainCtx, timeout)
+	defer cancel()
+
+	rs, err := ds2dhall.LoadInputs(ctx, flagSet.Args(), ignoreFiles)
+	if err != nil {
+		logFatal("failed to load resources", "error", err)
+	}
+
+	g, err := Build(rs)
+	if err != nil {
+		logFatal("failed to build graph", "error", err)
+	}
+	err = g.Write(os.Stdout, format)
+	if err != nil {
+		logFatal("failed to write graph", "error", err)
+	}
+	log15.Info("wrote graph", "nodes", len(g.Nodes), "edges", len(g.Edges))
+}
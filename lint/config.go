@@ -0,0 +1,104 @@
+package lint
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+type severity string
+
+const (
+	severityError   severity = "error"
+	severityWarning severity = "warning"
+	severityOff     severity = "off"
+)
+
+func parseSeverity(s string) (severity, error) {
+	switch sev := severity(s); sev {
+	case severityError, severityWarning, severityOff:
+		return sev, nil
+	}
+	return "", fmt.Errorf("unknown severity %q, expected error, warning or off", s)
+}
+
+// config sets the severity of the rules, read from a YAML file like
+//
+//	rules:
+//	  container-probes: error
+//	  pod-disruption-budget: off
+//	components:
+//	  cadvisor:
+//	    no-host-path: off
+//	    run-as-non-root: off
+type config struct {
+	// Rules override the default severity of rules for every component
+	Rules map[string]string `yaml:"rules"`
+	// Components override the severity of rules for single components
+	Components map[string]map[string]string `yaml:"components"`
+
+	severities          map[string]severity
+	componentSeverities map[string]map[string]severity
+}
+
+func parseSeverities(overrides map[string]string) (map[string]severity, error) {
+	severities := make(map[string]severity, len(overrides))
+	for id, s := range overrides {
+		if _, ok := lookupRule(id); !ok {
+			return nil, fmt.Errorf("unknown rule %q", id)
+		}
+		sev, err := parseSeverity(s)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", id, err)
+		}
+		severities[id] = sev
+	}
+	return severities, nil
+}
+
+func newConfig() *config {
+	return &config{
+		severities:          make(map[string]severity),
+		componentSeverities: make(map[string]map[string]severity),
+	}
+}
+
+func loadConfig(file string) (*config, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lint config %s: %w", file, err)
+	}
+
+	c.severities, err = parseSeverities(c.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lint config %s: %w", file, err)
+	}
+	for component, overrides := range c.Components {
+		c.componentSeverities[component], err = parseSeverities(overrides)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lint config %s: component %s: %w", file, component, err)
+		}
+	}
+	return c, nil
+}
+
+// severity returns the severity of the rule for resources of the component.
+func (c *config) severity(r *rule, component string) severity {
+	if sev, ok := c.componentSeverities[component][r.id]; ok {
+		return sev
+	}
+	if sev, ok := c.severities[r.id]; ok {
+		return sev
+	}
+	return r.defaultSeverity
+}
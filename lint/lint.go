@@ -0,0 +1,226 @@
+package lint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/console"
+	"ds-to-dhall/ds2dhall"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const ShortDescription = "checks Kubernetes manifests or a COMKIR Dhall record against deployment best practices"
+
+var (
+	configFile  string
+	format      string
+	ignoreFiles []string
+	timeout     time.Duration
+	listRules   bool
+
+	printHelp bool
+
+	flagSet *flag.FlagSet
+)
+
+func logFatal(message string, ctx ...interface{}) {
+	log15.Error(message, ctx...)
+	os.Exit(1)
+}
+
+func usageArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) a COMKIR Dhall record, or Kubernetes YAML files and directories containing them")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+func usageRules() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	for _, r := range rules {
+		fmt.Fprintf(w, "\t%s\t%s\t(%s)\n", r.id, r.description, r.defaultSeverity)
+	}
+	w.Flush()
+
+	return fmt.Sprintf("RULES:\n%s", b.String())
+}
+
+// finding is a rule broken by a resource.
+type finding struct {
+	Rule      string   `json:"rule"`
+	Severity  severity `json:"severity"`
+	Source    string   `json:"source"`
+	Component string   `json:"component"`
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Path      string   `json:"path"`
+	Message   string   `json:"message"`
+}
+
+// resourcePath is the COMKIR path of the resource the finding is about.
+func (f *finding) resourcePath() string {
+	return fmt.Sprintf("%s.%s.%s", f.Component, f.Kind, f.Name)
+}
+
+func (f *finding) String() string {
+	return fmt.Sprintf("%s: %s: %s: %s: %s (%s)", f.Source, f.resourcePath(), f.Path, f.Severity, f.Message, f.Rule)
+}
+
+// run checks every resource of the set with the rules that are not turned off for its component. The findings are
+// ordered by component, kind and name.
+func run(rs *comkir.ResourceSet, c *config) []*finding {
+	var resources []*comkir.Resource
+	for _, rsc := range rs.Components {
+		resources = append(resources, rsc...)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Component != b.Component {
+			return a.Component < b.Component
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	var findings []*finding
+	for _, res := range resources {
+		for _, r := range rules {
+			sev := c.severity(r, res.Component)
+			if sev == severityOff {
+				continue
+			}
+			for _, p := range r.check(rs, res) {
+				findings = append(findings, &finding{
+					Rule:      r.id,
+					Severity:  sev,
+					Source:    res.Source,
+					Component: res.Component,
+					Kind:      res.Kind,
+					Name:      res.Name,
+					Path:      p.path,
+					Message:   p.message,
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func writeFindings(w io.Writer, format string, findings []*finding) error {
+	switch format {
+	case "json":
+		if findings == nil {
+			findings = []*finding{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(findings)
+	case "sarif":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sarifLog(findings))
+	}
+	for _, f := range findings {
+		_, err := fmt.Fprintln(w, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags returns the flag set of lint, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
+	flagSet = flag.NewFlagSet("lint", flag.ExitOnError)
+
+	flagSet.StringVarP(&configFile, "config", "c", "",
+		"YAML file setting the severity (error, warning or off) of rules, for all or single components")
+	flagSet.StringVar(&format, "format", "text", "output format: text, json or sarif")
+	flagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
+	flagSet.DurationVar(&timeout, "timeout", 5*time.Minute, "length of time to run dhall-to-yaml before timing out")
+	flagSet.BoolVar(&listRules, "list-rules", false, "list the rules and their default severity")
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	flagSet.Usage = func() {
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "lint %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall lint: <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+		fmt.Fprintln(w, usageRules())
+	}
+	return flagSet
+}
+
+func Main(args []string, mainCtx context.Context) {
+	_ = Flags().Parse(args)
+
+	if printHelp {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if listRules {
+		fmt.Print(usageRules())
+		return
+	}
+
+	if len(flagSet.Args()) == 0 || (format != "text" && format != "json" && format != "sarif") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	c := newConfig()
+	if configFile != "" {
+		var err error
+		c, err = loadConfig(configFile)
+		if err != nil {
+			logFatal("failed to load config", "error", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(mainCtx, timeout)
+	defer cancel()
+
+	rs, err := ds2dhall.LoadInputs(ctx, flagSet.Args(), ignoreFiles)
+	if err != nil {
+		logFatal("failed to load resources", "error", err)
+	}
+
+	findings := run(rs, c)
+
+	var out bytes.Buffer
+	err = writeFindings(&out, format, findings)
+	if err != nil {
+		logFatal("failed to write findings", "error", err)
+	}
+	_, _ = os.Stdout.Write(out.Bytes())
+
+	numErrors := 0
+	for _, f := range findings {
+		if f.Severity == severityError {
+			numErrors++
+		}
+	}
+	if numErrors > 0 {
+		logFatal("resources break lint rules", "errors", numErrors, "warnings", len(findings)-numErrors)
+	}
+	log15.Info("lint passed", "warnings", len(findings))
+}
@@ -0,0 +1,158 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir/comkirtest"
+)
+
+const frontendDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: sourcegraph-frontend
+    spec:
+      securityContext:
+        runAsUser: 100
+      initContainers:
+      - name: migrator
+        image: sourcegraph/migrator:3.21.0
+      containers:
+      - name: frontend
+        image: sourcegraph/frontend:3.21.0
+        readinessProbe:
+          httpGet: {path: /healthz, port: http}
+        livenessProbe:
+          httpGet: {path: /healthz, port: http}
+        resources:
+          requests: {cpu: "2", memory: 2G}
+          limits: {cpu: "2", memory: 4G}
+`
+
+const cadvisorDaemonSet = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: cadvisor
+spec:
+  template:
+    spec:
+      containers:
+      - name: cadvisor
+        securityContext:
+          runAsNonRoot: false
+        readinessProbe:
+          httpGet: {path: /healthz, port: http}
+        resources:
+          requests: {cpu: 150m}
+          limits: {cpu: 300m}
+      volumes:
+      - name: rootfs
+        hostPath:
+          path: /
+`
+
+func TestRun(t *testing.T) {
+	rs := comkirtest.ResourceSet(t, map[string]string{
+		"frontend/Deployment/frontend": frontendDeployment,
+		"cadvisor/DaemonSet/cadvisor":  cadvisorDaemonSet,
+		"frontend/Service/sourcegraph": "apiVersion: v1\nkind: Service\nmetadata:\n  name: sourcegraph\n",
+	})
+
+	var lines []string
+	for _, f := range run(rs, newConfig()) {
+		lines = append(lines, f.String())
+	}
+	expected := []string{
+		"cadvisor/DaemonSet/cadvisor.yaml: cadvisor.DaemonSet.cadvisor: spec.template.spec.containers[0].livenessProbe: warning: container cadvisor has no livenessProbe (container-probes)",
+		"cadvisor/DaemonSet/cadvisor.yaml: cadvisor.DaemonSet.cadvisor: spec.template.spec.containers[0].securityContext: error: container cadvisor may run as root, set runAsNonRoot in spec.template.spec.securityContext or the container (run-as-non-root)",
+		"cadvisor/DaemonSet/cadvisor.yaml: cadvisor.DaemonSet.cadvisor: spec.template.spec.volumes[0].hostPath: error: volume rootfs mounts host path / (no-host-path)",
+		"frontend/Deployment/frontend.yaml: frontend.Deployment.frontend: spec.template.spec.initContainers[0].resources.requests: error: container migrator has no resource requests (container-resources)",
+		"frontend/Deployment/frontend.yaml: frontend.Deployment.frontend: spec.template.spec.initContainers[0].resources.limits: error: container migrator has no resource limits (container-resources)",
+		"frontend/Deployment/frontend.yaml: frontend.Deployment.frontend: spec.replicas: warning: deployment runs 2 replicas but no PodDisruptionBudget selects its pods (pod-disruption-budget)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	// a PodDisruptionBudget in another component covers the deployment with a selector expression, the config turns off
+	// the cadvisor findings
+	rs = comkirtest.ResourceSet(t, map[string]string{
+		"frontend/Deployment/frontend": frontendDeployment,
+		"cadvisor/DaemonSet/cadvisor":  cadvisorDaemonSet,
+		"pdbs/PodDisruptionBudget/frontend": `
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: frontend
+spec:
+  selector:
+    matchExpressions:
+    - {key: app, operator: In, values: [sourcegraph-frontend]}
+`,
+	})
+
+	dir, err := ioutil.TempDir("", "lint-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "lint.yaml")
+	err = ioutil.WriteFile(configFile, []byte(`
+rules:
+  container-resources: warning
+components:
+  cadvisor:
+    container-probes: off
+    run-as-non-root: off
+    no-host-path: off
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := run(rs, c)
+	if len(findings) != 2 || findings[0].Rule != "container-resources" || findings[0].Severity != severityWarning {
+		t.Errorf("expected two container-resources warnings, got %v", findings)
+	}
+
+	var b bytes.Buffer
+	err = writeFindings(&b, "sarif", findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarif
+	err = json.Unmarshal(b.Bytes(), &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 || log.Runs[0].Results[0].Level != "warning" ||
+		log.Runs[0].Results[0].Locations[0].LogicalLocations[0].FullyQualifiedName !=
+			"frontend.Deployment.frontend.spec.template.spec.initContainers[0].resources.requests" {
+		t.Errorf("unexpected SARIF log %s", b.String())
+	}
+
+	err = ioutil.WriteFile(configFile, []byte("rules:\n  latest-tag: error\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(configFile); err == nil {
+		t.Errorf("expected an error for an unknown rule")
+	}
+}
@@ -0,0 +1,209 @@
+package lint
+
+import (
+	"fmt"
+
+	"ds-to-dhall/comkir"
+)
+
+// problem is a rule broken by a resource at a field path.
+type problem struct {
+	path    string
+	message string
+}
+
+// rule is a best practice checked for every resource. The resource set is passed along for rules that need to look at
+// other resources.
+type rule struct {
+	id              string
+	description     string
+	defaultSeverity severity
+	check           func(rs *comkir.ResourceSet, res *comkir.Resource) []problem
+}
+
+var rules = []*rule{
+	{
+		id:              "container-resources",
+		description:     "every container sets resource requests and limits",
+		defaultSeverity: severityError,
+		check:           checkContainerResources,
+	},
+	{
+		id:              "container-probes",
+		description:     "every container has a readiness and a liveness probe",
+		defaultSeverity: severityWarning,
+		check:           checkContainerProbes,
+	},
+	{
+		id:              "run-as-non-root",
+		description:     "pods or all of their containers set runAsNonRoot",
+		defaultSeverity: severityError,
+		check:           checkRunAsNonRoot,
+	},
+	{
+		id:              "no-host-path",
+		description:     "pods do not mount hostPath volumes",
+		defaultSeverity: severityError,
+		check:           checkNoHostPath,
+	},
+	{
+		id:              "pod-disruption-budget",
+		description:     "deployments with more than one replica are covered by a PodDisruptionBudget",
+		defaultSeverity: severityWarning,
+		check:           checkPodDisruptionBudget,
+	},
+}
+
+func lookupRule(id string) (*rule, bool) {
+	for _, r := range rules {
+		if r.id == id {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// container is a container or init container of a pod spec.
+type container struct {
+	name   string
+	path   string
+	fields map[string]interface{}
+	init   bool
+}
+
+func containers(res *comkir.Resource) []container {
+	spec, specPath, ok := res.PodSpec()
+	if !ok {
+		return nil
+	}
+
+	var cs []container
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _ := spec[field].([]interface{})
+		for i, c := range list {
+			fields, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := fields["name"].(string)
+			cs = append(cs, container{
+				name:   name,
+				path:   fmt.Sprintf("%s.%s[%d]", specPath, field, i),
+				fields: fields,
+				init:   field == "initContainers",
+			})
+		}
+	}
+	return cs
+}
+
+func checkContainerResources(rs *comkir.ResourceSet, res *comkir.Resource) []problem {
+	var problems []problem
+	for _, c := range containers(res) {
+		for _, field := range []string{"requests", "limits"} {
+			values, ok := comkir.MapField(c.fields, "resources", field)
+			if !ok || len(values) == 0 {
+				problems = append(problems, problem{path: c.path + ".resources." + field,
+					message: fmt.Sprintf("container %s has no resource %s", c.name, field)})
+			}
+		}
+	}
+	return problems
+}
+
+func checkContainerProbes(rs *comkir.ResourceSet, res *comkir.Resource) []problem {
+	var problems []problem
+	for _, c := range containers(res) {
+		// init containers run to completion and cannot have probes
+		if c.init {
+			continue
+		}
+		for _, field := range []string{"readinessProbe", "livenessProbe"} {
+			if _, ok := comkir.MapField(c.fields, field); !ok {
+				problems = append(problems, problem{path: c.path + "." + field,
+					message: fmt.Sprintf("container %s has no %s", c.name, field)})
+			}
+		}
+	}
+	return problems
+}
+
+// runsAsNonRoot reports whether a security context sets runAsNonRoot or a non-zero runAsUser, and whether it decides
+// at all.
+func runsAsNonRoot(securityContext map[string]interface{}) (nonRoot bool, set bool) {
+	if v, ok := securityContext["runAsNonRoot"].(bool); ok {
+		return v, true
+	}
+	if uid, ok := securityContext["runAsUser"].(int); ok {
+		return uid != 0, true
+	}
+	return false, false
+}
+
+func checkRunAsNonRoot(rs *comkir.ResourceSet, res *comkir.Resource) []problem {
+	spec, specPath, ok := res.PodSpec()
+	if !ok {
+		return nil
+	}
+	podSecurityContext, _ := comkir.MapField(spec, "securityContext")
+	podNonRoot, _ := runsAsNonRoot(podSecurityContext)
+
+	var problems []problem
+	for _, c := range containers(res) {
+		containerSecurityContext, _ := comkir.MapField(c.fields, "securityContext")
+		nonRoot, set := runsAsNonRoot(containerSecurityContext)
+		// the container security context overrides the pod one
+		if (set && !nonRoot) || (!set && !podNonRoot) {
+			problems = append(problems, problem{path: c.path + ".securityContext",
+				message: fmt.Sprintf("container %s may run as root, set runAsNonRoot in %s.securityContext or the container",
+					c.name, specPath)})
+		}
+	}
+	return problems
+}
+
+func checkNoHostPath(rs *comkir.ResourceSet, res *comkir.Resource) []problem {
+	spec, specPath, ok := res.PodSpec()
+	if !ok {
+		return nil
+	}
+
+	var problems []problem
+	volumes, _ := spec["volumes"].([]interface{})
+	for i, v := range volumes {
+		volume, _ := v.(map[string]interface{})
+		if hostPath, ok := comkir.MapField(volume, "hostPath"); ok {
+			name, _ := volume["name"].(string)
+			problems = append(problems, problem{path: fmt.Sprintf("%s.volumes[%d].hostPath", specPath, i),
+				message: fmt.Sprintf("volume %s mounts host path %v", name, hostPath["path"])})
+		}
+	}
+	return problems
+}
+
+func checkPodDisruptionBudget(rs *comkir.ResourceSet, res *comkir.Resource) []problem {
+	if res.Kind != "Deployment" {
+		return nil
+	}
+	// a missing replicas field defaults to one
+	spec, _ := comkir.MapField(res.Contents, "spec")
+	replicas, _ := spec["replicas"].(int)
+	if replicas <= 1 {
+		return nil
+	}
+	labels, _ := res.PodLabels()
+
+	for _, resources := range rs.Components {
+		for _, other := range resources {
+			if other.Kind != "PodDisruptionBudget" {
+				continue
+			}
+			selector, ok := other.PodSelector()
+			if ok && selector.Matches(labels) {
+				return nil
+			}
+		}
+	}
+	return []problem{{path: "spec.replicas",
+		message: fmt.Sprintf("deployment runs %d replicas but no PodDisruptionBudget selects its pods", replicas)}}
+}
@@ -0,0 +1,113 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID                   string                 `json:"id"`
+	ShortDescription     sarifText              `json:"shortDescription"`
+	DefaultConfiguration sarifRuleConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifRuleConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+// sarif is the subset of the SARIF 2.1.0 format (https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html)
+// written by --format sarif, which code scanning tools like GitHub's can import.
+type sarif struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sarifURI makes sources below the working directory relative to it, as code scanning tools expect paths relative to
+// the repository.
+func sarifURI(source string) string {
+	cwd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(source) {
+		return filepath.ToSlash(source)
+	}
+	rel, err := filepath.Rel(cwd, source)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(source)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func sarifLog(findings []*finding) *sarif {
+	driver := sarifDriver{Name: "ds-to-dhall lint"}
+	for _, r := range rules {
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   r.id,
+			ShortDescription:     sarifText{Text: r.description},
+			DefaultConfiguration: sarifRuleConfiguration{Level: string(r.defaultSeverity)},
+		})
+	}
+
+	results := []sarifResult{}
+	for _, f := range findings {
+		results = append(results, sarifResult{
+			RuleID:  f.Rule,
+			Level:   string(f.Severity),
+			Message: sarifText{Text: f.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: sarifURI(f.Source)}},
+				LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: f.resourcePath() + "." + f.Path}},
+			}},
+		})
+	}
+
+	return &sarif{
+		Version: "2.1.0",
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+}
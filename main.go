@@ -10,10 +10,9 @@ import (
 	"syscall"
 	"text/tabwriter"
 
-	"ds-to-dhall/dhall2ds"
-	"ds-to-dhall/dockerimg"
+	"ds-to-dhall/console"
 	"ds-to-dhall/ds2dhall"
-	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
 )
 
 var (
@@ -22,6 +21,14 @@ var (
 	date    = "unknown"
 )
 
+var (
+	logLevel     string
+	logFormat    string
+	quiet        bool
+	printHelp    bool
+	printVersion bool
+)
+
 func versionString(version, commit, date string) string {
 	b := bytes.Buffer{}
 	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
@@ -36,66 +43,50 @@ func versionString(version, commit, date string) string {
 	return b.String()
 }
 
+// usageGlobalOptions describes the options that go before the subcommand.
+func usageGlobalOptions(flagSet *flag.FlagSet) string {
+	return fmt.Sprintf("global options:\n%s", flagSet.FlagUsages())
+}
+
 func main() {
-	cmds := make(map[string]func([]string, context.Context))
-	shortDescriptions := make(map[string]string)
-	cmds["ds2dhall"] = ds2dhall.Main
-	shortDescriptions["ds2dhall"] = ds2dhall.ShortDescription
-	cmds["dockerimg"] = dockerimg.Main
-	shortDescriptions["dockerimg"] = dockerimg.ShortDescription
-	cmds["dhall2ds"] = dhall2ds.Main
-	shortDescriptions["dhall2ds"] = dhall2ds.ShortDescription
-
-	cmdNames := make([]string, 0, len(cmds)+2)
-	for cmdName := range cmds {
-		cmdNames = append(cmdNames, cmdName)
+	ds2dhall.ToolVersion = version
+
+	flagSet := flag.NewFlagSet("ds-to-dhall", flag.ExitOnError)
+	flagSet.StringVar(&logLevel, "log-level", "info", "minimum level of the logged messages: debug, info, warn, error or crit")
+	flagSet.StringVar(&logFormat, "log-format", "logfmt", "format of the log messages on stderr: logfmt, json or human")
+	flagSet.BoolVarP(&quiet, "quiet", "q", false, "only log errors and do not show progress spinners")
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+	flagSet.BoolVarP(&printVersion, "version", "v", false, "print the version")
+
+	commands := newCommandRegistry(flagSet)
+	flagSet.Usage = func() {
+		commands.writeUsage(os.Stderr)
 	}
-	cmdNames = append(cmdNames, "help")
-	cmdNames = append(cmdNames, "version")
 
-	log15.Root().SetHandler(log15.StreamHandler(os.Stdout, log15.LogfmtFormat()))
+	// global options end at the command, everything after it belongs to the command
+	flagSet.SetInterspersed(false)
+	_ = flagSet.Parse(os.Args[1:])
+	args := flagSet.Args()
 
-	if len(os.Args) < 2 {
-		fmt.Printf("expected a subcommand: %s\n", strings.Join(cmdNames, ", "))
+	err := console.Setup(logLevel, logFormat, quiet)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	if os.Args[1] == "help" || os.Args[1] == "--help" || os.Args[1] == "-h" {
-		if len(os.Args) == 2 || os.Args[1] == "--help" || os.Args[1] == "-h" {
-			fmt.Println("ds-to-dhall <command>")
-			fmt.Println("available commands:")
-			w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
-
-			for cmdName := range cmds {
-				fmt.Fprintf(w, "\t%s\t%s\n", cmdName, shortDescriptions[cmdName])
-			}
-			fmt.Fprintln(w, "\thelp\tshows help for commands")
-			fmt.Fprintln(w, "\tversion\tshows version string")
-			w.Flush()
-			os.Exit(0)
-		}
-
-		cmd, ok := cmds[os.Args[2]]
-		if !ok {
-			fmt.Printf("unknown subcommand %s\n", os.Args[1])
-			fmt.Printf("expected a subcommand: %s\n", strings.Join(cmdNames, ", "))
-			os.Exit(1)
-		}
-
-		cmd([]string{"-h"}, context.Background())
-	}
-
-	if os.Args[1] == "version" || os.Args[1] == "--version" || os.Args[1] == "-v" {
-		output := versionString(version, commit, date)
-		fmt.Fprintln(os.Stderr, output)
-		os.Exit(0)
+	switch {
+	case printVersion:
+		args = []string{"version"}
+	case printHelp:
+		args = []string{"help"}
+	case len(args) == 0:
+		fmt.Fprintf(os.Stderr, "expected a subcommand: %s\n", strings.Join(commands.names(), ", "))
+		os.Exit(1)
 	}
 
-	cmd, ok := cmds[os.Args[1]]
+	cmd, ok := commands.lookup(args[0])
 	if !ok {
-		fmt.Printf("unknown subcommand %s\n", os.Args[1])
-		fmt.Printf("expected a subcommand: %s\n", strings.Join(cmdNames, ", "))
-		os.Exit(1)
+		commands.unknownCommand(args[0])
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -106,7 +97,7 @@ func main() {
 
 	go trapSignalsForShutdown(shutdown)
 
-	cmd(os.Args[2:], ctx)
+	cmd.main(args[1:], ctx)
 }
 
 func trapSignalsForShutdown(shutdown func()) {
@@ -0,0 +1,286 @@
+// Package openapi validates the contents of resources against the schema definitions of a Kubernetes OpenAPI (v2)
+// spec, like the api/openapi-spec/swagger.json of the Kubernetes repository.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"ds-to-dhall/comkir"
+)
+
+const (
+	definitionsRef = "#/definitions/"
+	quantityType   = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+)
+
+// GroupVersionKind identifies the schema of a resource.
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (gvk GroupVersionKind) String() string {
+	if gvk.Group == "" {
+		return gvk.Version + " " + gvk.Kind
+	}
+	return gvk.Group + "/" + gvk.Version + " " + gvk.Kind
+}
+
+// ResourceGVK returns the group, version and kind of a resource from its apiVersion and kind.
+func ResourceGVK(res *comkir.Resource) GroupVersionKind {
+	gvk := GroupVersionKind{Version: res.ApiVersion, Kind: res.Kind}
+	if i := strings.LastIndex(res.ApiVersion, "/"); i >= 0 {
+		gvk.Group, gvk.Version = res.ApiVersion[:i], res.ApiVersion[i+1:]
+	}
+	return gvk
+}
+
+// Schema is the subset of an OpenAPI schema object that is used by the Kubernetes definitions.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties *Schema            `json:"additionalProperties"`
+
+	PreserveUnknownFields bool               `json:"x-kubernetes-preserve-unknown-fields"`
+	GroupVersionKinds     []GroupVersionKind `json:"x-kubernetes-group-version-kind"`
+}
+
+// UnmarshalJSON also accepts a boolean schema, which allows anything when true.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if json.Unmarshal(data, &b) == nil {
+		*s = Schema{PreserveUnknownFields: b}
+		return nil
+	}
+	type schema Schema
+	return json.Unmarshal(data, (*schema)(s))
+}
+
+// Spec holds the definitions of an OpenAPI spec, indexed by the group, version and kind they describe.
+type Spec struct {
+	Definitions map[string]*Schema `json:"definitions"`
+
+	kinds map[GroupVersionKind]string
+}
+
+// LoadSpec reads an OpenAPI v2 spec in JSON.
+func LoadSpec(file string) (*Spec, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpec(contents)
+}
+
+// ParseSpec parses the JSON contents of an OpenAPI v2 spec.
+func ParseSpec(contents []byte) (*Spec, error) {
+	var spec Spec
+	err := json.Unmarshal(contents, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+	if len(spec.Definitions) == 0 {
+		return nil, fmt.Errorf("OpenAPI spec has no definitions")
+	}
+
+	spec.kinds = make(map[GroupVersionKind]string)
+	for name, s := range spec.Definitions {
+		for _, gvk := range s.GroupVersionKinds {
+			spec.kinds[gvk] = name
+		}
+	}
+	return &spec, nil
+}
+
+// ValidationError is a problem with a single field of a resource.
+type ValidationError struct {
+	Source  string `json:"source"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s %s: %s", e.Source, e.Kind, e.Name, e.Message)
+	}
+	return fmt.Sprintf("%s: %s %s: %s: %s", e.Source, e.Kind, e.Name, e.Path, e.Message)
+}
+
+type validator struct {
+	spec   *Spec
+	res    *comkir.Resource
+	errors []*ValidationError
+}
+
+func (v *validator) fail(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ValidationError{
+		Source:  v.res.Source,
+		Kind:    v.res.Kind,
+		Name:    v.res.Name,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func fieldPath(path string, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// typeName describes the type of a decoded YAML value in error messages.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (v *validator) validate(path string, s *Schema, value interface{}) {
+	// null is the same as leaving the field out
+	if value == nil || s == nil {
+		return
+	}
+
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, definitionsRef)
+		if name == quantityType {
+			if t := typeName(value); t != "string" && t != "integer" && t != "number" {
+				v.fail(path, "expected a quantity, got %s", t)
+			}
+			return
+		}
+		def, ok := v.spec.Definitions[name]
+		if !ok {
+			v.fail(path, "schema refers to unknown definition %s", s.Ref)
+			return
+		}
+		v.validate(path, def, value)
+		return
+	}
+
+	actual := typeName(value)
+	switch {
+	case s.Format == "int-or-string":
+		if actual != "string" && actual != "integer" {
+			v.fail(path, "expected an integer or string, got %s", actual)
+		}
+	case s.Type == "object" || (s.Type == "" && s.Properties != nil):
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(path, "expected object, got %s", actual)
+			return
+		}
+		v.validateObject(path, s, obj)
+	case s.Type == "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			v.fail(path, "expected array, got %s", actual)
+			return
+		}
+		for i, item := range list {
+			v.validate(fmt.Sprintf("%s[%d]", path, i), s.Items, item)
+		}
+	case s.Type == "number":
+		if actual != "number" && actual != "integer" {
+			v.fail(path, "expected number, got %s", actual)
+		}
+	case s.Type == "string" || s.Type == "integer" || s.Type == "boolean":
+		if actual != s.Type {
+			v.fail(path, "expected %s, got %s", s.Type, actual)
+		}
+	}
+}
+
+func (v *validator) validateObject(path string, s *Schema, obj map[string]interface{}) {
+	for _, field := range s.Required {
+		if _, ok := obj[field]; !ok {
+			v.fail(fieldPath(path, field), "missing required field")
+		}
+	}
+
+	fields := make([]string, 0, len(obj))
+	for field := range obj {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if fs, ok := s.Properties[field]; ok {
+			v.validate(fieldPath(path, field), fs, obj[field])
+			continue
+		}
+		switch {
+		case s.AdditionalProperties != nil:
+			v.validate(fieldPath(path, field), s.AdditionalProperties, obj[field])
+		case s.PreserveUnknownFields || s.Properties == nil:
+			// free form object
+		default:
+			v.fail(fieldPath(path, field), "unknown field")
+		}
+	}
+}
+
+// Validate checks the contents of the resource against the schema of its group, version and kind and returns every
+// problem found.
+func (spec *Spec) Validate(res *comkir.Resource) []*ValidationError {
+	v := &validator{spec: spec, res: res}
+
+	gvk := ResourceGVK(res)
+	name, ok := spec.kinds[gvk]
+	if !ok {
+		v.fail("", "no schema for %s", gvk)
+		return v.errors
+	}
+
+	v.validate("", spec.Definitions[name], res.Contents)
+	return v.errors
+}
+
+// ValidateResourceSet validates every resource of the set, ordered by source file, kind and name.
+func (spec *Spec) ValidateResourceSet(rs *comkir.ResourceSet) []*ValidationError {
+	var resources []*comkir.Resource
+	for _, rsc := range rs.Components {
+		resources = append(resources, rsc...)
+	}
+	// the items of a List and the resources of a Dhall record share their source
+	sort.SliceStable(resources, func(i, j int) bool {
+		ri, rj := resources[i], resources[j]
+		if ri.Source != rj.Source {
+			return ri.Source < rj.Source
+		}
+		if ri.Kind != rj.Kind {
+			return ri.Kind < rj.Kind
+		}
+		return ri.Name < rj.Name
+	})
+
+	var errors []*ValidationError
+	for _, res := range resources {
+		errors = append(errors, spec.Validate(res)...)
+	}
+	return errors
+}
@@ -0,0 +1,165 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir"
+	"gopkg.in/yaml.v3"
+)
+
+const testSpec = `{
+  "definitions": {
+    "io.k8s.api.core.v1.Service": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.ServiceSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Service", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.ServiceSpec": {
+      "type": "object",
+      "properties": {
+        "ports": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.ServicePort"}},
+        "selector": {"type": "object", "additionalProperties": {"type": "string"}},
+        "publishNotReadyAddresses": {"type": "boolean"}
+      }
+    },
+    "io.k8s.api.core.v1.ServicePort": {
+      "type": "object",
+      "required": ["port"],
+      "properties": {
+        "name": {"type": "string"},
+        "port": {"type": "integer", "format": "int32"},
+        "targetPort": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+        "creationTimestamp": {"type": "string", "format": "date-time"}
+      }
+    },
+    "io.k8s.apimachinery.pkg.util.intstr.IntOrString": {"type": "string", "format": "int-or-string"}
+  }
+}`
+
+func testResource(t *testing.T, contents string) *comkir.Resource {
+	res := &comkir.Resource{Source: "base/frontend/frontend.Service.yaml", Kind: "Service", Name: "frontend"}
+	err := yaml.Unmarshal([]byte(contents), &res.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ApiVersion = res.Contents["apiVersion"].(string)
+	res.Kind = res.Contents["kind"].(string)
+	return res
+}
+
+func TestValidate(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := testResource(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: frontend
+  creationTimestamp: null
+  labels:
+    app: frontend
+spec:
+  ports:
+  - name: http
+    port: 30080
+    targetPort: http
+  - port: 6060
+    targetPort: 6060
+  selector:
+    app: frontend
+`)
+	if errs := spec.Validate(valid); len(errs) > 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+
+	invalid := testResource(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: frontend
+  labels:
+    replicas: 2
+spec:
+  ports:
+  - name: http
+    targetPort: [http]
+  - port: "6060"
+    protocol: TCP
+  publishNotReadyAddresses: "yes"
+`)
+	var messages []string
+	for _, e := range spec.Validate(invalid) {
+		messages = append(messages, e.Error())
+	}
+	expected := []string{
+		"base/frontend/frontend.Service.yaml: Service frontend: metadata.labels.replicas: expected string, got integer",
+		"base/frontend/frontend.Service.yaml: Service frontend: spec.ports[0].port: missing required field",
+		"base/frontend/frontend.Service.yaml: Service frontend: spec.ports[0].targetPort: expected an integer or string, got array",
+		"base/frontend/frontend.Service.yaml: Service frontend: spec.ports[1].port: expected integer, got string",
+		"base/frontend/frontend.Service.yaml: Service frontend: spec.ports[1].protocol: unknown field",
+		"base/frontend/frontend.Service.yaml: Service frontend: spec.publishNotReadyAddresses: expected boolean, got string",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+
+	unknown := testResource(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: frontend\n")
+	errs := spec.Validate(unknown)
+	if len(errs) != 1 || errs[0].Message != "no schema for apps/v1 Deployment" {
+		t.Errorf("expected a missing schema error, got %v", errs)
+	}
+}
+
+func TestValidateResourceSetOrder(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &comkir.ResourceSet{Components: make(map[string][]*comkir.Resource)}
+	for i, name := range []string{"searcher", "frontend", "gitserver", "indexed-search"} {
+		res := testResource(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: "+name+"\n")
+		res.Source = "dump/all.yaml"
+		res.Name = name
+		res.Component = name
+		if i == 3 {
+			res.Source = "base/indexed-search.Deployment.yaml"
+		}
+		rs.Components[res.Component] = append(rs.Components[res.Component], res)
+	}
+	service := testResource(t, "apiVersion: v1\nkind: Service\nmetadata:\n  name: searcher\n  namespace: prod\n")
+	service.Source = "dump/all.yaml"
+	service.Name = "searcher"
+	rs.Components["searcher"] = append(rs.Components["searcher"], service)
+
+	var messages []string
+	for _, e := range spec.ValidateResourceSet(rs) {
+		messages = append(messages, e.Error())
+	}
+	expected := []string{
+		"base/indexed-search.Deployment.yaml: Deployment indexed-search: no schema for apps/v1 Deployment",
+		"dump/all.yaml: Deployment frontend: no schema for apps/v1 Deployment",
+		"dump/all.yaml: Deployment gitserver: no schema for apps/v1 Deployment",
+		"dump/all.yaml: Deployment searcher: no schema for apps/v1 Deployment",
+		"dump/all.yaml: Service searcher: metadata.namespace: unknown field",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+}
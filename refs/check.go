@@ -0,0 +1,360 @@
+package refs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ds-to-dhall/comkir"
+)
+
+// reference is a resource named by another resource at a field path.
+type reference struct {
+	kind      string
+	namespace string
+	name      string
+	path      string
+}
+
+// Problem is a dangling reference or a selector that matches nothing.
+type Problem struct {
+	Source  string `json:"source"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: %s %s: %s: %s", p.Source, p.Kind, p.Name, p.Path, p.Message)
+}
+
+// sameNamespace reports whether resources in the namespaces can refer to each other. Resources without a namespace
+// are created in the namespace kubectl is pointed to, which can be any.
+func sameNamespace(a, b string) bool {
+	return a == b || a == "" || b == ""
+}
+
+// checker resolves references against the resources of a set and the resources known to exist outside of it.
+type checker struct {
+	resources []*comkir.Resource
+	// byName are the resources by kind/name
+	byName map[string][]*comkir.Resource
+	// external are the kind/name pairs of resources created outside of the set
+	external map[string]bool
+}
+
+// newChecker indexes the resources of the set. external are kind/name pairs of resources that are created outside of
+// the set, like Secret/tls-certificate.
+func newChecker(rs *comkir.ResourceSet, external []string) (*checker, error) {
+	c := &checker{byName: make(map[string][]*comkir.Resource), external: make(map[string]bool)}
+	for _, resources := range rs.Components {
+		for _, res := range resources {
+			c.resources = append(c.resources, res)
+			key := res.Kind + "/" + res.Name
+			c.byName[key] = append(c.byName[key], res)
+		}
+	}
+	sort.Slice(c.resources, func(i, j int) bool {
+		a, b := c.resources[i], c.resources[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	for _, e := range external {
+		parts := strings.SplitN(e, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("external resource %q is not of the form Kind/name", e)
+		}
+		// external resources match references from any namespace
+		c.external[e] = true
+	}
+	return c, nil
+}
+
+// resolve returns the resources of the set the reference can refer to.
+func (c *checker) resolve(ref reference) []*comkir.Resource {
+	var resolved []*comkir.Resource
+	for _, res := range c.byName[ref.kind+"/"+ref.name] {
+		if sameNamespace(res.Namespace(), ref.namespace) {
+			resolved = append(resolved, res)
+		}
+	}
+	return resolved
+}
+
+func (c *checker) exists(ref reference) bool {
+	return c.external[ref.kind+"/"+ref.name] || len(c.resolve(ref)) > 0
+}
+
+func stringField(m map[string]interface{}, field string) string {
+	s, _ := m[field].(string)
+	return s
+}
+
+func optional(m map[string]interface{}) bool {
+	o, _ := m["optional"].(bool)
+	return o
+}
+
+// podReferences are the config maps, secrets, claims and service account used by the pods of a workload.
+func podReferences(res *comkir.Resource) []reference {
+	spec, specPath, ok := res.PodSpec()
+	if !ok {
+		return nil
+	}
+	namespace := res.Namespace()
+	var refs []reference
+	add := func(kind string, name string, path string) {
+		if name != "" {
+			refs = append(refs, reference{kind: kind, namespace: namespace, name: name, path: path})
+		}
+	}
+
+	// every namespace has a default service account
+	if sa := stringField(spec, "serviceAccountName"); sa != "" && sa != "default" {
+		add("ServiceAccount", sa, specPath+".serviceAccountName")
+	}
+
+	pullSecrets, _ := spec["imagePullSecrets"].([]interface{})
+	for i, s := range pullSecrets {
+		secret, _ := s.(map[string]interface{})
+		add("Secret", stringField(secret, "name"), fmt.Sprintf("%s.imagePullSecrets[%d]", specPath, i))
+	}
+
+	volumes, _ := spec["volumes"].([]interface{})
+	for i, v := range volumes {
+		volume, _ := v.(map[string]interface{})
+		path := fmt.Sprintf("%s.volumes[%d]", specPath, i)
+		if cm, ok := comkir.MapField(volume, "configMap"); ok && !optional(cm) {
+			add("ConfigMap", stringField(cm, "name"), path+".configMap")
+		}
+		if secret, ok := comkir.MapField(volume, "secret"); ok && !optional(secret) {
+			add("Secret", stringField(secret, "secretName"), path+".secret")
+		}
+		if claim, ok := comkir.MapField(volume, "persistentVolumeClaim"); ok {
+			add("PersistentVolumeClaim", stringField(claim, "claimName"), path+".persistentVolumeClaim")
+		}
+		sources, _ := comkir.MapField(volume, "projected")
+		projections, _ := sources["sources"].([]interface{})
+		for j, p := range projections {
+			projection, _ := p.(map[string]interface{})
+			projectionPath := fmt.Sprintf("%s.projected.sources[%d]", path, j)
+			if cm, ok := comkir.MapField(projection, "configMap"); ok && !optional(cm) {
+				add("ConfigMap", stringField(cm, "name"), projectionPath+".configMap")
+			}
+			if secret, ok := comkir.MapField(projection, "secret"); ok && !optional(secret) {
+				add("Secret", stringField(secret, "name"), projectionPath+".secret")
+			}
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for i, c := range containers {
+			container, _ := c.(map[string]interface{})
+			containerPath := fmt.Sprintf("%s.%s[%d]", specPath, field, i)
+
+			env, _ := container["env"].([]interface{})
+			for j, e := range env {
+				variable, _ := e.(map[string]interface{})
+				path := fmt.Sprintf("%s.env[%d].valueFrom", containerPath, j)
+				if ref, ok := comkir.MapField(variable, "valueFrom", "configMapKeyRef"); ok && !optional(ref) {
+					add("ConfigMap", stringField(ref, "name"), path+".configMapKeyRef")
+				}
+				if ref, ok := comkir.MapField(variable, "valueFrom", "secretKeyRef"); ok && !optional(ref) {
+					add("Secret", stringField(ref, "name"), path+".secretKeyRef")
+				}
+			}
+
+			envFrom, _ := container["envFrom"].([]interface{})
+			for j, e := range envFrom {
+				source, _ := e.(map[string]interface{})
+				path := fmt.Sprintf("%s.envFrom[%d]", containerPath, j)
+				if ref, ok := comkir.MapField(source, "configMapRef"); ok && !optional(ref) {
+					add("ConfigMap", stringField(ref, "name"), path+".configMapRef")
+				}
+				if ref, ok := comkir.MapField(source, "secretRef"); ok && !optional(ref) {
+					add("Secret", stringField(ref, "name"), path+".secretRef")
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// bindingReferences are the role and service accounts of a RoleBinding or ClusterRoleBinding.
+func bindingReferences(res *comkir.Resource) []reference {
+	if res.Kind != "RoleBinding" && res.Kind != "ClusterRoleBinding" {
+		return nil
+	}
+	var refs []reference
+
+	if roleRef, ok := comkir.MapField(res.Contents, "roleRef"); ok {
+		refs = append(refs, reference{kind: stringField(roleRef, "kind"), namespace: res.Namespace(),
+			name: stringField(roleRef, "name"), path: "roleRef"})
+	}
+
+	subjects, _ := res.Contents["subjects"].([]interface{})
+	for i, s := range subjects {
+		subject, _ := s.(map[string]interface{})
+		if stringField(subject, "kind") != "ServiceAccount" {
+			continue
+		}
+		namespace := stringField(subject, "namespace")
+		if namespace == "" {
+			namespace = res.Namespace()
+		}
+		refs = append(refs, reference{kind: "ServiceAccount", namespace: namespace, name: stringField(subject, "name"),
+			path: fmt.Sprintf("subjects[%d]", i)})
+	}
+	return refs
+}
+
+// ingressReferences are the services of the backends of an Ingress, in the extensions/v1beta1 and the
+// networking.k8s.io/v1 format.
+func ingressReferences(res *comkir.Resource) []reference {
+	if res.Kind != "Ingress" {
+		return nil
+	}
+	var refs []reference
+	add := func(backend map[string]interface{}, path string) {
+		name := stringField(backend, "serviceName")
+		if service, ok := comkir.MapField(backend, "service"); ok {
+			name = stringField(service, "name")
+		}
+		if name != "" {
+			refs = append(refs, reference{kind: "Service", namespace: res.Namespace(), name: name, path: path})
+		}
+	}
+
+	spec, _ := comkir.MapField(res.Contents, "spec")
+	for _, field := range []string{"backend", "defaultBackend"} {
+		if backend, ok := comkir.MapField(spec, field); ok {
+			add(backend, "spec."+field)
+		}
+	}
+	rules, _ := spec["rules"].([]interface{})
+	for i, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		http, _ := comkir.MapField(rule, "http")
+		paths, _ := http["paths"].([]interface{})
+		for j, p := range paths {
+			path, _ := p.(map[string]interface{})
+			if backend, ok := comkir.MapField(path, "backend"); ok {
+				add(backend, fmt.Sprintf("spec.rules[%d].http.paths[%d].backend", i, j))
+			}
+		}
+	}
+	return refs
+}
+
+// selected returns the workloads in the namespace whose pods match the selector.
+func (c *checker) selected(selector *comkir.LabelSelector, namespace string) []*comkir.Resource {
+	var workloads []*comkir.Resource
+	for _, res := range c.resources {
+		labels, ok := res.PodLabels()
+		if ok && sameNamespace(res.Namespace(), namespace) && selector.Matches(labels) {
+			workloads = append(workloads, res)
+		}
+	}
+	return workloads
+}
+
+// selectsPods reports whether the selector matches the pods of any workload in the namespace.
+func (c *checker) selectsPods(selector *comkir.LabelSelector, namespace string) bool {
+	return len(c.selected(selector, namespace)) > 0
+}
+
+// serviceSelector returns the selector of a Service or PodDisruptionBudget, which select the pods of other resources.
+func serviceSelector(res *comkir.Resource) (*comkir.LabelSelector, bool) {
+	if res.Kind != "Service" && res.Kind != "PodDisruptionBudget" {
+		return nil, false
+	}
+	return res.PodSelector()
+}
+
+// selectorProblems reports the selectors of services and pod disruption budgets that match no pods, and the
+// selectors of workloads that do not match their own pod template.
+func (c *checker) selectorProblems(res *comkir.Resource) []*Problem {
+	var problems []*Problem
+	fail := func(path string, format string, args ...interface{}) {
+		problems = append(problems, &Problem{Source: res.Source, Kind: res.Kind, Name: res.Name, Path: path,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	selector, ok := res.PodSelector()
+	switch res.Kind {
+	case "Service":
+		// services without a selector have manually managed endpoints
+		if ok && !selector.Empty() && !c.selectsPods(selector, res.Namespace()) {
+			fail("spec.selector", "selector %s matches no pods", selector)
+		}
+	case "PodDisruptionBudget":
+		if !c.selectsPods(selector, res.Namespace()) {
+			fail("spec.selector", "selector %s matches no pods", selector)
+		}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet":
+		labels, _ := res.PodLabels()
+		if ok && !selector.Matches(labels) {
+			fail("spec.selector", "selector %s does not match the labels of the pod template", selector)
+		}
+	}
+	return problems
+}
+
+// references are the resources named by a resource.
+func references(res *comkir.Resource) []reference {
+	var refs []reference
+	refs = append(refs, podReferences(res)...)
+	refs = append(refs, bindingReferences(res)...)
+	refs = append(refs, ingressReferences(res)...)
+	return refs
+}
+
+// check returns the problems of every resource, ordered by source.
+func (c *checker) check() []*Problem {
+	var problems []*Problem
+	for _, res := range c.resources {
+		for _, ref := range references(res) {
+			if !c.exists(ref) {
+				problems = append(problems, &Problem{Source: res.Source, Kind: res.Kind, Name: res.Name, Path: ref.path,
+					Message: fmt.Sprintf("references %s %s which does not exist", ref.kind, ref.name)})
+			}
+		}
+		problems = append(problems, c.selectorProblems(res)...)
+	}
+	return problems
+}
+
+// Link is a reference or selector of one resource that resolves to another resource of the set.
+type Link struct {
+	From *comkir.Resource
+	To   *comkir.Resource
+	// Path is the field of From holding the reference or selector
+	Path string
+}
+
+// links returns the resolved references and selectors of every resource, ordered by the source of the referring
+// resource. Dangling references and selectors that match nothing have no links.
+func (c *checker) links() []Link {
+	var links []Link
+	for _, res := range c.resources {
+		for _, ref := range references(res) {
+			for _, to := range c.resolve(ref) {
+				links = append(links, Link{From: res, To: to, Path: ref.path})
+			}
+		}
+		if selector, ok := serviceSelector(res); ok {
+			for _, to := range c.selected(selector, res.Namespace()) {
+				links = append(links, Link{From: res, To: to, Path: "spec.selector"})
+			}
+		}
+	}
+	return links
+}
@@ -0,0 +1,168 @@
+package refs
+
+import (
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir/comkirtest"
+)
+
+func TestCheck(t *testing.T) {
+	rs := comkirtest.ResourceSet(t, map[string]string{
+		"sourcegraph/Deployment/frontend": `
+kind: Deployment
+metadata:
+  name: frontend
+spec:
+  selector:
+    matchLabels:
+      app: sourcegraph-frontend
+  template:
+    metadata:
+      labels:
+        app: sourcegraph-frontend
+    spec:
+      serviceAccountName: sourcegraph-frontend
+      imagePullSecrets:
+      - name: docker-registry
+      containers:
+      - name: frontend
+        env:
+        - name: PGPASSWORD
+          valueFrom:
+            secretKeyRef:
+              name: pgsql-auth
+              key: password
+        - name: SITE_CONFIG
+          valueFrom:
+            configMapKeyRef:
+              name: site-config
+              key: config
+              optional: true
+        envFrom:
+        - configMapRef:
+            name: frontend-env
+      volumes:
+      - name: cache
+        persistentVolumeClaim:
+          claimName: frontend-cache
+      - name: tls
+        secret:
+          secretName: sourcegraph-tls
+`,
+		"sourcegraph/Service/sourcegraph-frontend": `
+kind: Service
+metadata:
+  name: sourcegraph-frontend
+spec:
+  selector:
+    app: sourcegraph-frontend
+`,
+		"sourcegraph/Service/gitserver": `
+kind: Service
+metadata:
+  name: gitserver
+spec:
+  selector:
+    app: gitserver
+`,
+		"sourcegraph/StatefulSet/redis": `
+kind: StatefulSet
+metadata:
+  name: redis
+spec:
+  selector:
+    matchLabels:
+      app: redis-cache
+  template:
+    metadata:
+      labels:
+        app: redis-store
+    spec:
+      containers:
+      - name: redis
+`,
+		"sourcegraph/ConfigMap/frontend-env": `
+kind: ConfigMap
+metadata:
+  name: frontend-env
+`,
+		"sourcegraph/PodDisruptionBudget/frontend": `
+kind: PodDisruptionBudget
+metadata:
+  name: frontend
+spec:
+  selector:
+    matchExpressions:
+    - {key: app, operator: In, values: [sourcegraph-frontend, frontend]}
+    - {key: canary, operator: DoesNotExist}
+`,
+		"sourcegraph/PodDisruptionBudget/searcher": `
+kind: PodDisruptionBudget
+metadata:
+  name: searcher
+spec:
+  selector:
+    matchExpressions:
+    - {key: app, operator: In, values: [searcher]}
+`,
+		"sourcegraph/ClusterRoleBinding/sourcegraph-frontend": `
+kind: ClusterRoleBinding
+metadata:
+  name: sourcegraph-frontend
+roleRef:
+  kind: ClusterRole
+  name: view
+subjects:
+- kind: ServiceAccount
+  name: sourcegraph-frontend
+  namespace: default
+`,
+		"sourcegraph/Ingress/sourcegraph-frontend": `
+kind: Ingress
+metadata:
+  name: sourcegraph-frontend
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        backend:
+          serviceName: sourcegraph-frontend
+          servicePort: 30080
+      - path: /metrics
+        backend:
+          service:
+            name: prometheus
+`,
+	})
+
+	problems, err := Check(rs, []string{"Secret/sourcegraph-tls"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	expected := []string{
+		"sourcegraph/ClusterRoleBinding/sourcegraph-frontend.yaml: ClusterRoleBinding sourcegraph-frontend: roleRef: references ClusterRole view which does not exist",
+		"sourcegraph/ClusterRoleBinding/sourcegraph-frontend.yaml: ClusterRoleBinding sourcegraph-frontend: subjects[0]: references ServiceAccount sourcegraph-frontend which does not exist",
+		"sourcegraph/Deployment/frontend.yaml: Deployment frontend: spec.template.spec.serviceAccountName: references ServiceAccount sourcegraph-frontend which does not exist",
+		"sourcegraph/Deployment/frontend.yaml: Deployment frontend: spec.template.spec.imagePullSecrets[0]: references Secret docker-registry which does not exist",
+		"sourcegraph/Deployment/frontend.yaml: Deployment frontend: spec.template.spec.volumes[0].persistentVolumeClaim: references PersistentVolumeClaim frontend-cache which does not exist",
+		"sourcegraph/Deployment/frontend.yaml: Deployment frontend: spec.template.spec.containers[0].env[0].valueFrom.secretKeyRef: references Secret pgsql-auth which does not exist",
+		"sourcegraph/Ingress/sourcegraph-frontend.yaml: Ingress sourcegraph-frontend: spec.rules[0].http.paths[1].backend: references Service prometheus which does not exist",
+		"sourcegraph/PodDisruptionBudget/searcher.yaml: PodDisruptionBudget searcher: spec.selector: selector {app in (searcher)} matches no pods",
+		"sourcegraph/Service/gitserver.yaml: Service gitserver: spec.selector: selector {app=gitserver} matches no pods",
+		"sourcegraph/StatefulSet/redis.yaml: StatefulSet redis: spec.selector: selector {app=redis-cache} does not match the labels of the pod template",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	if _, err := Check(rs, []string{"sourcegraph-tls"}); err == nil {
+		t.Errorf("expected an error for an external resource without a kind")
+	}
+}
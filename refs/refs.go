@@ -0,0 +1,134 @@
+package refs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/console"
+	"ds-to-dhall/ds2dhall"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const ShortDescription = "reports references to missing resources and selectors that match no pods"
+
+var (
+	external    []string
+	format      string
+	ignoreFiles []string
+	timeout     time.Duration
+
+	printHelp bool
+
+	flagSet *flag.FlagSet
+)
+
+func logFatal(message string, ctx ...interface{}) {
+	log15.Error(message, ctx...)
+	os.Exit(1)
+}
+
+func usageArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) a COMKIR Dhall record, or Kubernetes YAML files and directories containing them")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+// Check resolves the ConfigMap, Secret, PersistentVolumeClaim, ServiceAccount, role and service references and the
+// label selectors of every resource in the set. external are Kind/name pairs of resources created outside of the set.
+func Check(rs *comkir.ResourceSet, external []string) ([]*Problem, error) {
+	c, err := newChecker(rs, external)
+	if err != nil {
+		return nil, err
+	}
+	return c.check(), nil
+}
+
+// Links resolves the references and selectors of every resource in the set to the resources they point to.
+func Links(rs *comkir.ResourceSet) ([]Link, error) {
+	c, err := newChecker(rs, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.links(), nil
+}
+
+// Flags returns the flag set of refs, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
+	flagSet = flag.NewFlagSet("refs", flag.ExitOnError)
+
+	flagSet.StringArrayVarP(&external, "external", "e", nil,
+		"resource created outside of the inputs as Kind/name, like Secret/sourcegraph-tls. references to it are not reported")
+	flagSet.StringVar(&format, "format", "text", "output format: text or json")
+	flagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
+	flagSet.DurationVar(&timeout, "timeout", 5*time.Minute, "length of time to run dhall-to-yaml before timing out")
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	flagSet.Usage = func() {
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "refs %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall refs: <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+	}
+	return flagSet
+}
+
+func Main(args []string, mainCtx context.Context) {
+	_ = Flags().Parse(args)
+
+	if printHelp {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(flagSet.Args()) == 0 || (format != "text" && format != "json") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(mainCtx, timeout)
+	defer cancel()
+
+	rs, err := ds2dhall.LoadInputs(ctx, flagSet.Args(), ignoreFiles)
+	if err != nil {
+		logFatal("failed to load resources", "error", err)
+	}
+
+	problems, err := Check(rs, external)
+	if err != nil {
+		logFatal("invalid --external", "error", err)
+	}
+
+	if format == "json" {
+		if problems == nil {
+			problems = []*Problem{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(problems)
+		if err != nil {
+			logFatal("failed to write problems", "error", err)
+		}
+	} else {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	}
+
+	if len(problems) > 0 {
+		logFatal("resources have broken references", "problems", len(problems))
+	}
+	log15.Info("all references resolve")
+}
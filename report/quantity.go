@@ -0,0 +1,116 @@
+package report
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// quantitySuffixes are the multipliers of the binary and decimal SI suffixes of Kubernetes quantities.
+var quantitySuffixes = map[string]*big.Rat{
+	"n":  big.NewRat(1, 1000000000),
+	"u":  big.NewRat(1, 1000000),
+	"m":  big.NewRat(1, 1000),
+	"":   big.NewRat(1, 1),
+	"k":  big.NewRat(1000, 1),
+	"M":  new(big.Rat).SetInt(pow(10, 6)),
+	"G":  new(big.Rat).SetInt(pow(10, 9)),
+	"T":  new(big.Rat).SetInt(pow(10, 12)),
+	"P":  new(big.Rat).SetInt(pow(10, 15)),
+	"E":  new(big.Rat).SetInt(pow(10, 18)),
+	"Ki": new(big.Rat).SetInt(pow(2, 10)),
+	"Mi": new(big.Rat).SetInt(pow(2, 20)),
+	"Gi": new(big.Rat).SetInt(pow(2, 30)),
+	"Ti": new(big.Rat).SetInt(pow(2, 40)),
+	"Pi": new(big.Rat).SetInt(pow(2, 50)),
+	"Ei": new(big.Rat).SetInt(pow(2, 60)),
+}
+
+func pow(base, exp int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(base), big.NewInt(exp), nil)
+}
+
+// parseQuantity parses a Kubernetes quantity like 500m, 1.5Gi, 2 or 1e3 exactly. YAML numbers are accepted too, as
+// manifests often write cpu: 2.
+func parseQuantity(value interface{}) (*big.Rat, error) {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case int, int64:
+		s = fmt.Sprint(v)
+	case float64:
+		// fmt.Sprint formats large floats with an exponent like 1e+21
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil, fmt.Errorf("quantity %v is not a string or a number", value)
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '+' && r != '-'
+	})
+	number, suffix := s, ""
+	if i >= 0 {
+		number, suffix = s[:i], s[i:]
+	}
+	q, ok := new(big.Rat).SetString(number)
+	if number == "" || !ok {
+		return nil, fmt.Errorf("invalid quantity %q", s)
+	}
+
+	if multiplier, ok := quantitySuffixes[suffix]; ok {
+		return q.Mul(q, multiplier), nil
+	}
+	// a decimal exponent like 1e3 or 5E-2, E alone is the exa suffix
+	if len(suffix) > 1 && (suffix[0] == 'e' || suffix[0] == 'E') {
+		exp, err := strconv.Atoi(suffix[1:])
+		if err == nil && exp >= -18 && exp <= 18 {
+			multiplier := new(big.Rat).SetInt(pow(10, int64(abs(exp))))
+			if exp < 0 {
+				multiplier.Inv(multiplier)
+			}
+			return q.Mul(q, multiplier), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid quantity %q", s)
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// ceil rounds the quantity up to an integer multiple of unit, like Kubernetes rounds 0.1m up to 1m.
+func ceil(q *big.Rat, unit int64) (int64, error) {
+	scaled := new(big.Rat).Mul(q, big.NewRat(unit, 1))
+	quotient, remainder := new(big.Int).DivMod(scaled.Num(), scaled.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("quantity %s is too large", q.FloatString(3))
+	}
+	return quotient.Int64(), nil
+}
+
+// formatCores formats millicores as cores, like 2.5.
+func formatCores(millicores int64) string {
+	s := strconv.FormatFloat(float64(millicores)/1000, 'f', 3, 64)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// formatBytes formats bytes with the largest binary suffix that keeps the value at least 1, like 1.5Gi.
+func formatBytes(bytes int64) string {
+	suffixes := []string{"Ei", "Pi", "Ti", "Gi", "Mi", "Ki"}
+	for i, suffix := range suffixes {
+		unit := int64(1) << uint(10*(len(suffixes)-i))
+		if bytes >= unit {
+			s := strconv.FormatFloat(float64(bytes)/float64(unit), 'f', 1, 64)
+			return strings.TrimSuffix(s, ".0") + suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
@@ -0,0 +1,103 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ds-to-dhall/console"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const ShortDescription = "reports on the resources of a deployment"
+
+var (
+	ignoreFiles []string
+	timeout     time.Duration
+
+	printHelp bool
+
+	flagSet *flag.FlagSet
+)
+
+func logFatal(message string, ctx ...interface{}) {
+	log15.Error(message, ctx...)
+	os.Exit(1)
+}
+
+func usageArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) a COMKIR Dhall record, or Kubernetes YAML files and directories containing them")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+func usageSubcommands() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	for _, sub := range Subcommands() {
+		fmt.Fprintf(w, "\t%s\t%s\n", sub.Name, sub.ShortDescription)
+	}
+	w.Flush()
+
+	return fmt.Sprintf("SUBCOMMANDS:\n%s", b.String())
+}
+
+// Subcommand is a report, like report resources.
+type Subcommand struct {
+	Name             string
+	ShortDescription string
+	Main             func([]string, context.Context)
+	Flags            func() *flag.FlagSet
+}
+
+// Subcommands returns the reports, selected by the first argument.
+func Subcommands() []Subcommand {
+	return []Subcommand{
+		{Name: "resources", ShortDescription: resourcesShortDescription, Main: resourcesMain, Flags: resourcesFlags},
+	}
+}
+
+// Flags returns the flag set of report, which only selects a subcommand.
+func Flags() *flag.FlagSet {
+	flagSet = flag.NewFlagSet("report", flag.ExitOnError)
+
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	flagSet.Usage = func() {
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "report %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall report: <subcommand> <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(w, usageSubcommands())
+	}
+	return flagSet
+}
+
+func Main(args []string, ctx context.Context) {
+	if len(args) > 0 {
+		for _, sub := range Subcommands() {
+			if sub.Name == args[0] {
+				sub.Main(args[1:], ctx)
+				return
+			}
+		}
+	}
+
+	_ = Flags().Parse(args)
+
+	flagSet.Usage()
+	if printHelp {
+		os.Exit(0)
+	}
+	os.Exit(1)
+}
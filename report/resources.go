@@ -0,0 +1,364 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ds-to-dhall/comkir"
+	"ds-to-dhall/console"
+	"ds-to-dhall/ds2dhall"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const resourcesShortDescription = "tallies the CPU, memory and storage requested by each component"
+
+var (
+	resourcesFormat string
+	nodes           int64
+
+	resourcesFlagSet *flag.FlagSet
+)
+
+// usage is the CPU in millicores and the memory and storage in bytes requested by resources.
+type usage struct {
+	CPURequests    int64 `json:"cpuRequestsMillicores"`
+	CPULimits      int64 `json:"cpuLimitsMillicores"`
+	MemoryRequests int64 `json:"memoryRequestsBytes"`
+	MemoryLimits   int64 `json:"memoryLimitsBytes"`
+	Storage        int64 `json:"storageBytes"`
+}
+
+// mulAdd returns total + value*times, or an error if it does not fit in an int64.
+func mulAdd(total, value, times int64) (int64, error) {
+	r := new(big.Int).Mul(big.NewInt(value), big.NewInt(times))
+	r.Add(r, big.NewInt(total))
+	if !r.IsInt64() {
+		return 0, fmt.Errorf("total %s is too large", r)
+	}
+	return r.Int64(), nil
+}
+
+// add adds o times to the usage.
+func (u *usage) add(o usage, times int64) error {
+	for _, f := range []struct {
+		total *int64
+		value int64
+	}{
+		{&u.CPURequests, o.CPURequests},
+		{&u.CPULimits, o.CPULimits},
+		{&u.MemoryRequests, o.MemoryRequests},
+		{&u.MemoryLimits, o.MemoryLimits},
+		{&u.Storage, o.Storage},
+	} {
+		total, err := mulAdd(*f.total, f.value, times)
+		if err != nil {
+			return err
+		}
+		*f.total = total
+	}
+	return nil
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// componentUsage is the usage of the workloads and claims of a component.
+type componentUsage struct {
+	Component string `json:"component"`
+	usage
+}
+
+type resourcesReport struct {
+	Components []*componentUsage `json:"components"`
+	Total      usage             `json:"total"`
+}
+
+// quantityField parses the quantity at field of m, and rounds it up to a multiple of 1/unit.
+func quantityField(m map[string]interface{}, field string, unit int64) (int64, bool, error) {
+	v, ok := m[field]
+	if !ok || v == nil {
+		return 0, false, nil
+	}
+	q, err := parseQuantity(v)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", field, err)
+	}
+	n, err := ceil(q, unit)
+	return n, true, err
+}
+
+// containerUsage returns the requests and limits of a container. Like the API server does, a container with a limit
+// but no request requests its limit.
+func containerUsage(container map[string]interface{}) (usage, error) {
+	var u usage
+	requests, _ := comkir.MapField(container, "resources", "requests")
+	limits, _ := comkir.MapField(container, "resources", "limits")
+
+	for _, r := range []struct {
+		name           string
+		unit           int64
+		request, limit *int64
+	}{
+		{"cpu", 1000, &u.CPURequests, &u.CPULimits},
+		{"memory", 1, &u.MemoryRequests, &u.MemoryLimits},
+	} {
+		limit, hasLimit, err := quantityField(limits, r.name, r.unit)
+		if err != nil {
+			return u, fmt.Errorf("resources.limits.%w", err)
+		}
+		request, hasRequest, err := quantityField(requests, r.name, r.unit)
+		if err != nil {
+			return u, fmt.Errorf("resources.requests.%w", err)
+		}
+		if !hasRequest && hasLimit {
+			request = limit
+		}
+		*r.request, *r.limit = request, limit
+	}
+	return u, nil
+}
+
+// podUsage returns the usage of a pod, the sum of its containers or the largest init container if that is larger, as
+// the scheduler counts it.
+func podUsage(spec map[string]interface{}, specPath string) (usage, error) {
+	var sum, init usage
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for i, c := range containers {
+			container, _ := c.(map[string]interface{})
+			u, err := containerUsage(container)
+			if err != nil {
+				return usage{}, fmt.Errorf("%s.%s[%d].%w", specPath, field, i, err)
+			}
+			if field == "containers" {
+				if err := sum.add(u, 1); err != nil {
+					return usage{}, fmt.Errorf("%s.%s: %w", specPath, field, err)
+				}
+				continue
+			}
+			init.CPURequests = max(init.CPURequests, u.CPURequests)
+			init.CPULimits = max(init.CPULimits, u.CPULimits)
+			init.MemoryRequests = max(init.MemoryRequests, u.MemoryRequests)
+			init.MemoryLimits = max(init.MemoryLimits, u.MemoryLimits)
+		}
+	}
+	return usage{
+		CPURequests:    max(sum.CPURequests, init.CPURequests),
+		CPULimits:      max(sum.CPULimits, init.CPULimits),
+		MemoryRequests: max(sum.MemoryRequests, init.MemoryRequests),
+		MemoryLimits:   max(sum.MemoryLimits, init.MemoryLimits),
+	}, nil
+}
+
+// intField returns the count at path in m, or def if it is not set. Negative counts are an error.
+func intField(m map[string]interface{}, def int64, path ...string) (int64, error) {
+	parent, ok := comkir.MapField(m, path[:len(path)-1]...)
+	if !ok {
+		return def, nil
+	}
+	var n int64
+	switch v := parent[path[len(path)-1]].(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case float64:
+		n = int64(v)
+	default:
+		return def, nil
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s: %d is negative", strings.Join(path, "."), n)
+	}
+	return n, nil
+}
+
+// podCount returns the number of pods a workload runs, counting a pod per node for DaemonSets.
+func podCount(res *comkir.Resource, nodes int64) (int64, error) {
+	switch res.Kind {
+	case "Deployment", "StatefulSet", "ReplicaSet":
+		return intField(res.Contents, 1, "spec", "replicas")
+	case "DaemonSet":
+		return nodes, nil
+	case "Job":
+		return intField(res.Contents, 1, "spec", "parallelism")
+	case "CronJob":
+		return intField(res.Contents, 1, "spec", "jobTemplate", "spec", "parallelism")
+	}
+	return 1, nil
+}
+
+// claimStorage returns the storage requested by a PersistentVolumeClaim spec.
+func claimStorage(spec map[string]interface{}) (int64, error) {
+	requests, _ := comkir.MapField(spec, "resources", "requests")
+	storage, _, err := quantityField(requests, "storage", 1)
+	if err != nil {
+		return 0, fmt.Errorf("resources.requests.%w", err)
+	}
+	return storage, nil
+}
+
+// resourceUsage returns the usage of a resource, and whether it is a workload or claim that counts towards usage.
+func resourceUsage(res *comkir.Resource, nodes int64) (usage, bool, error) {
+	var u usage
+	if res.Kind == "PersistentVolumeClaim" {
+		spec, _ := comkir.MapField(res.Contents, "spec")
+		storage, err := claimStorage(spec)
+		if err != nil {
+			return u, false, fmt.Errorf("spec.%w", err)
+		}
+		u.Storage = storage
+		return u, true, nil
+	}
+
+	spec, specPath, ok := res.PodSpec()
+	if !ok {
+		return u, false, nil
+	}
+	pod, err := podUsage(spec, specPath)
+	if err != nil {
+		return u, false, err
+	}
+	replicas, err := podCount(res, nodes)
+	if err != nil {
+		return u, false, err
+	}
+	if err := u.add(pod, replicas); err != nil {
+		return u, false, err
+	}
+
+	// every replica of a StatefulSet gets a claim from each template
+	templates, _ := comkir.MapField(res.Contents, "spec")
+	claims, _ := templates["volumeClaimTemplates"].([]interface{})
+	for i, c := range claims {
+		claim, _ := c.(map[string]interface{})
+		claimSpec, _ := comkir.MapField(claim, "spec")
+		storage, err := claimStorage(claimSpec)
+		if err != nil {
+			return u, false, fmt.Errorf("spec.volumeClaimTemplates[%d].spec.%w", i, err)
+		}
+		u.Storage, err = mulAdd(u.Storage, storage, replicas)
+		if err != nil {
+			return u, false, fmt.Errorf("spec.volumeClaimTemplates[%d]: %w", i, err)
+		}
+	}
+	return u, true, nil
+}
+
+// tally sums the usage of the workloads and claims of every component. DaemonSets run a pod on each of nodes.
+func tally(rs *comkir.ResourceSet, nodes int64) (*resourcesReport, error) {
+	r := &resourcesReport{Components: []*componentUsage{}}
+	for component, resources := range rs.Components {
+		c := &componentUsage{Component: component}
+		counted := false
+		for _, res := range resources {
+			u, ok, err := resourceUsage(res, nodes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s %s: %w", res.Source, res.Kind, res.Name, err)
+			}
+			if !ok {
+				continue
+			}
+			if err := c.add(u, 1); err != nil {
+				return nil, fmt.Errorf("component %s: %w", component, err)
+			}
+			counted = true
+		}
+		if counted {
+			r.Components = append(r.Components, c)
+			if err := r.Total.add(c.usage, 1); err != nil {
+				return nil, fmt.Errorf("total: %w", err)
+			}
+		}
+	}
+	sort.Slice(r.Components, func(i, j int) bool {
+		return r.Components[i].Component < r.Components[j].Component
+	})
+	return r, nil
+}
+
+func writeResourcesText(w io.Writer, r *resourcesReport) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	row := func(name string, u usage) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", name, formatCores(u.CPURequests), formatCores(u.CPULimits),
+			formatBytes(u.MemoryRequests), formatBytes(u.MemoryLimits), formatBytes(u.Storage))
+	}
+	fmt.Fprintln(tw, "COMPONENT\tCPU REQUESTS\tCPU LIMITS\tMEMORY REQUESTS\tMEMORY LIMITS\tSTORAGE")
+	for _, c := range r.Components {
+		row(c.Component, c.usage)
+	}
+	row("TOTAL", r.Total)
+	return tw.Flush()
+}
+
+func resourcesFlags() *flag.FlagSet {
+	resourcesFlagSet = flag.NewFlagSet("report resources", flag.ExitOnError)
+
+	resourcesFlagSet.StringVar(&resourcesFormat, "format", "text", "output format: text or json")
+	resourcesFlagSet.Int64Var(&nodes, "nodes", 1, "number of nodes of the cluster, each of which runs a pod of every DaemonSet")
+	resourcesFlagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
+	resourcesFlagSet.DurationVar(&timeout, "timeout", 5*time.Minute, "length of time to run dhall-to-yaml before timing out")
+	resourcesFlagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	resourcesFlagSet.Usage = func() {
+		w := console.UsageOutput(resourcesFlagSet)
+		fmt.Fprintf(w, "report resources %s\n", resourcesShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall report resources: <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		resourcesFlagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+	}
+	return resourcesFlagSet
+}
+
+func resourcesMain(args []string, mainCtx context.Context) {
+	_ = resourcesFlags().Parse(args)
+
+	if printHelp {
+		resourcesFlagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(resourcesFlagSet.Args()) == 0 || (resourcesFormat != "text" && resourcesFormat != "json") || nodes < 0 {
+		resourcesFlagSet.Usage()
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(mainCtx, timeout)
+	defer cancel()
+
+	rs, err := ds2dhall.LoadInputs(ctx, resourcesFlagSet.Args(), ignoreFiles)
+	if err != nil {
+		logFatal("failed to load resources", "error", err)
+	}
+
+	r, err := tally(rs, nodes)
+	if err != nil {
+		logFatal("failed to tally resources", "error", err)
+	}
+
+	if resourcesFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(r)
+	} else {
+		err = writeResourcesText(os.Stdout, r)
+	}
+	if err != nil {
+		logFatal("failed to write report", "error", err)
+	}
+	log15.Info("tallied resources", "components", len(r.Components))
+}
@@ -0,0 +1,179 @@
+package report
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"ds-to-dhall/comkir/comkirtest"
+)
+
+func TestParseQuantity(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		unit  int64
+		want  int64
+	}{
+		{"500m", 1000, 500},
+		{"1.5", 1000, 1500},
+		{2, 1000, 2000},
+		{0.25, 1000, 250},
+		{"0.1m", 1000, 1},
+		{"1Gi", 1, 1 << 30},
+		{"1.5Gi", 1, 3 << 29},
+		{"2G", 1, 2000000000},
+		{"128974848", 1, 128974848},
+		{"129e6", 1, 129000000},
+		{"1E", 1, 1000000000000000000},
+		{"12Mi", 1, 12 << 20},
+		{1e18, 1, 1000000000000000000},
+		{1e-5, 1000, 1},
+	} {
+		q, err := parseQuantity(tc.value)
+		if err != nil {
+			t.Errorf("%v: %v", tc.value, err)
+			continue
+		}
+		got, err := ceil(q, tc.unit)
+		if err != nil || got != tc.want {
+			t.Errorf("%v: expected %d, got %d (%v)", tc.value, tc.want, got, err)
+		}
+	}
+
+	// YAML decodes 1e21 as a float that fmt.Sprint formats as 1e+21
+	q, err := parseQuantity(1e21)
+	if err != nil || q.Cmp(new(big.Rat).SetInt(pow(10, 21))) != 0 {
+		t.Errorf("1e21: expected 10^21, got %v (%v)", q, err)
+	}
+
+	for _, value := range []interface{}{"", "Gi", "1Gb", "1.2.3", "1e", true} {
+		if _, err := parseQuantity(value); err == nil {
+			t.Errorf("expected an error for %v", value)
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	rs := comkirtest.ResourceSet(t, map[string]string{
+		"frontend/Deployment/frontend": `
+spec:
+  replicas: 2
+  template:
+    spec:
+      initContainers:
+      - name: migrator
+        resources:
+          requests: {cpu: "3", memory: 1Gi}
+      containers:
+      - name: frontend
+        resources:
+          requests: {cpu: "2", memory: 2Gi}
+          limits: {cpu: "2", memory: 4Gi}
+      - name: jaeger-agent
+        resources:
+          limits: {cpu: 500m, memory: 500M}
+`,
+		"frontend/Service/sourcegraph-frontend": "spec: {}",
+		"gitserver/StatefulSet/gitserver": `
+spec:
+  template:
+    spec:
+      containers:
+      - name: gitserver
+        resources:
+          requests: {cpu: 4, memory: 8Gi}
+  volumeClaimTemplates:
+  - spec:
+      resources:
+        requests: {storage: 200Gi}
+`,
+		"cadvisor/DaemonSet/cadvisor": `
+spec:
+  template:
+    spec:
+      containers:
+      - name: cadvisor
+        resources:
+          requests: {cpu: 150m, memory: 200Mi}
+`,
+		"pgsql/PersistentVolumeClaim/pgsql": "spec:\n  resources:\n    requests: {storage: 200Gi}\n",
+		"pgsql/ConfigMap/pgsql-conf":        "data: {}",
+	})
+
+	r, err := tally(rs, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = writeResourcesText(&b, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `COMPONENT  CPU REQUESTS  CPU LIMITS  MEMORY REQUESTS  MEMORY LIMITS  STORAGE
+cadvisor   0.45          0           600Mi            0              0
+frontend   6             5           4.9Gi            8.9Gi          0
+gitserver  4             0           8Gi              0              200Gi
+pgsql      0             0           0                0              200Gi
+TOTAL      10.45         5           13.5Gi           8.9Gi          400Gi
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+
+	rs = comkirtest.ResourceSet(t, map[string]string{
+		"frontend/Deployment/frontend": `
+spec:
+  template:
+    spec:
+      containers:
+      - name: frontend
+        resources:
+          requests: {cpu: two}
+`,
+	})
+	_, err = tally(rs, 1)
+	if err == nil || !strings.Contains(err.Error(), "spec.template.spec.containers[0].resources.requests.cpu") {
+		t.Errorf("expected an error with the path of the invalid quantity, got %v", err)
+	}
+
+	// 7Ei fits in an int64 but two replicas of it do not
+	rs = comkirtest.ResourceSet(t, map[string]string{
+		"gitserver/StatefulSet/gitserver": `
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: gitserver
+  volumeClaimTemplates:
+  - spec:
+      resources:
+        requests: {storage: 7Ei}
+`,
+	})
+	_, err = tally(rs, 1)
+	if err == nil || !strings.Contains(err.Error(), "spec.volumeClaimTemplates[0]: total") {
+		t.Errorf("expected an error for the overflowing storage total, got %v", err)
+	}
+
+	rs = comkirtest.ResourceSet(t, map[string]string{
+		"indexer/CronJob/indexer": `
+spec:
+  jobTemplate:
+    spec:
+      parallelism: -2
+      template:
+        spec:
+          containers:
+          - name: indexer
+            resources:
+              requests: {cpu: 1}
+`,
+	})
+	_, err = tally(rs, 1)
+	if err == nil || !strings.Contains(err.Error(), "spec.jobTemplate.spec.parallelism: -2 is negative") {
+		t.Errorf("expected an error for the negative parallelism, got %v", err)
+	}
+}
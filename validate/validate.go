@@ -0,0 +1,144 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"ds-to-dhall/console"
+	"ds-to-dhall/ds2dhall"
+	"ds-to-dhall/openapi"
+	"github.com/inconshreveable/log15"
+	flag "github.com/spf13/pflag"
+)
+
+const ShortDescription = "validates Kubernetes manifests against a Kubernetes OpenAPI spec"
+
+var (
+	specFile    string
+	ignoreFiles []string
+	format      string
+
+	printHelp bool
+
+	flagSet *flag.FlagSet
+)
+
+func logFatal(message string, ctx ...interface{}) {
+	log15.Error(message, ctx...)
+	os.Exit(1)
+}
+
+func usageArgs() string {
+	b := bytes.Buffer{}
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+
+	fmt.Fprintln(w, "\t<path>\t(required) list of Kubernetes YAML files (or directories containing them) to validate")
+	w.Flush()
+
+	return fmt.Sprintf("ARGS:\n%s", b.String())
+}
+
+// Flags returns the flag set of validate, with its flags bound to the package options.
+func Flags() *flag.FlagSet {
+	flagSet = flag.NewFlagSet("validate", flag.ExitOnError)
+
+	flagSet.StringVarP(&specFile, "spec", "s", "",
+		"(required) Kubernetes OpenAPI v2 spec, like api/openapi-spec/swagger.json of the Kubernetes release")
+	flagSet.StringArrayVarP(&ignoreFiles, "ignore", "i", nil, "input files matching these gitignore patterns will be ignored")
+	flagSet.StringVar(&format, "format", "text", "output format: text or json")
+	flagSet.BoolVarP(&printHelp, "help", "h", false, "print usage instructions")
+
+	flagSet.Usage = func() {
+		w := console.UsageOutput(flagSet)
+		fmt.Fprintf(w, "validate %s\n", ShortDescription)
+		fmt.Fprintf(w, "Usage of ds-to-dhall validate: --spec <spec> <path>...\n")
+		fmt.Fprintln(w, "OPTIONS:")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(w, usageArgs())
+	}
+	return flagSet
+}
+
+// validateInputs validates the resources of the YAML files in inputs against spec. Manifests that cannot be loaded as
+// resources, like resources without a name, are reported with the validation errors, ordered by source file.
+func validateInputs(spec *openapi.Spec, inputs []string, ignore []string) ([]*openapi.ValidationError, error) {
+	rs, problems, err := ds2dhall.LoadResourceSetProblems(inputs, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	var validationErrors []*openapi.ValidationError
+	for _, p := range problems {
+		validationErrors = append(validationErrors, &openapi.ValidationError{
+			Source:  p.Source,
+			Kind:    p.Kind,
+			Path:    p.Path,
+			Message: p.Message,
+		})
+	}
+	validationErrors = append(validationErrors, spec.ValidateResourceSet(rs)...)
+	sort.SliceStable(validationErrors, func(i, j int) bool {
+		return validationErrors[i].Source < validationErrors[j].Source
+	})
+	return validationErrors, nil
+}
+
+// writeValidationErrors writes the validation errors in the text or json format to w.
+func writeValidationErrors(w io.Writer, validationErrors []*openapi.ValidationError, format string) error {
+	if format == "json" {
+		if validationErrors == nil {
+			validationErrors = []*openapi.ValidationError{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(validationErrors)
+	}
+
+	for _, e := range validationErrors {
+		_, err := fmt.Fprintln(w, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Main(args []string, ctx context.Context) {
+	_ = Flags().Parse(args)
+
+	if printHelp {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if specFile == "" || len(flagSet.Args()) == 0 || (format != "text" && format != "json") {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	spec, err := openapi.LoadSpec(specFile)
+	if err != nil {
+		logFatal("failed to load OpenAPI spec", "error", err, "spec", specFile)
+	}
+
+	validationErrors, err := validateInputs(spec, flagSet.Args(), ignoreFiles)
+	if err != nil {
+		logFatal("failed to load resources", "error", err)
+	}
+
+	err = writeValidationErrors(os.Stdout, validationErrors, format)
+	if err != nil {
+		logFatal("failed to write validation errors", "error", err)
+	}
+
+	if len(validationErrors) > 0 {
+		logFatal("resources do not match the OpenAPI spec", "errors", len(validationErrors))
+	}
+	log15.Info("all resources are valid")
+}
@@ -0,0 +1,131 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ds-to-dhall/openapi"
+)
+
+const testSpec = `{
+  "definitions": {
+    "io.k8s.api.core.v1.Service": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.ServiceSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Service", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.ServiceSpec": {
+      "type": "object",
+      "properties": {
+        "selector": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"}
+      }
+    }
+  }
+}`
+
+func TestValidateInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifests := map[string]string{
+		"base/frontend.Service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: frontend\nspec:\n  selector:\n    replicas: 2\n",
+		"base/searcher.Service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  labels: {}\n",
+		"dump/all.yaml": `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: symbols
+  spec:
+    ports: []
+- apiVersion: v1
+  kind: Service
+  metadata: {}
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: gitserver
+    namespace: prod
+`,
+	}
+	for path, manifest := range manifests {
+		err = os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, path), []byte(manifest), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spec, err := openapi.ParseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validationErrors, err := validateInputs(spec, []string{dir}, []string{"*.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = writeValidationErrors(&b, validationErrors, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.ReplaceAll(b.String(), dir+string(os.PathSeparator), "")
+	expected := strings.Join([]string{
+		"base/frontend.Service.yaml: Service frontend: spec.selector.replicas: expected string, got integer",
+		"base/searcher.Service.yaml: Service : metadata.name: missing name field",
+		"dump/all.yaml: Service : items[1].metadata.name: missing name field",
+		"dump/all.yaml: Service gitserver: metadata.namespace: unknown field",
+		"dump/all.yaml: Service symbols: spec.ports: unknown field",
+	}, "\n") + "\n"
+	if text != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, text)
+	}
+
+	b.Reset()
+	err = writeValidationErrors(&b, validationErrors, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*openapi.ValidationError
+	err = json.Unmarshal(b.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 5 || decoded[2].Path != "items[1].metadata.name" || decoded[2].Kind != "Service" {
+		t.Errorf("expected the load problem in the json output, got %s", b.String())
+	}
+
+	b.Reset()
+	err = writeValidationErrors(&b, nil, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(b.String()) != "[]" {
+		t.Errorf("expected an empty json array without errors, got %s", b.String())
+	}
+}